@@ -0,0 +1,125 @@
+// Copyright © 2021 DataHen Canada Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+
+	"github.com/DataHenHQ/henqa/qa"
+	"github.com/spf13/cobra"
+)
+
+// compareCmd represents the compare command
+var compareCmd = &cobra.Command{
+	Use:   "compare <old-file> <new-file>",
+	Short: "Compares the records of two data files joined on a key.",
+	Long: `Compares the records of two .csv, .tsv, .json, .ndjson, .parquet or .xlsx files, such as two scrapes
+of the same site, joining them on the --key fields. The added, removed and changed records are written
+into the reports folder with the summary and details layout of a validation, where each change is an
+error of the new file: <key>.added, <key>.removed and <field>.changed with the old and new values.
+Records without key or with a key already seen in their file are reported as missing_key and
+duplicate_key. The records of the old file are held in memory.
+For example:
+henqa compare yesterday/products.json today/products.json --key sku
+henqa compare old.csv new.csv --key sku --key color --ignore-field scraped_at -o product-changes
+`,
+	Args:         cobra.ExactArgs(2),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		keys, err := cmd.Flags().GetStringSlice("key")
+		if err != nil {
+			return usageError(err)
+		}
+		if len(keys) == 0 {
+			return usageError(qa.ErrNoCompareKeys)
+		}
+		ignoreFields, err := cmd.Flags().GetStringSlice("ignore-field")
+		if err != nil {
+			return usageError(err)
+		}
+		outDir, err := cmd.Flags().GetString("output-dir")
+		if err != nil {
+			return usageError(err)
+		}
+		summaryFile, err := cmd.Flags().GetString("summary-file")
+		if err != nil {
+			return usageError(err)
+		}
+		detailsFormat, err := cmd.Flags().GetString("details-format")
+		if err != nil {
+			return usageError(err)
+		}
+		if detailsFormat != qa.DetailsFormatJSON && detailsFormat != qa.DetailsFormatNDJSON {
+			return usageError(fmt.Errorf("unknown details format %q", detailsFormat))
+		}
+		batchSize, err := cmd.Flags().GetInt("batch-size")
+		if err != nil {
+			return usageError(err)
+		}
+		if batchSize < 1 {
+			return usageError(errors.New("Batch size must be at least 1"))
+		}
+		maxErrors, err := cmd.Flags().GetInt("max")
+		if err != nil {
+			return usageError(err)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		v := qa.NewValidator(qa.Options{
+			OutDir:            outDir,
+			SummaryFile:       summaryFile,
+			DetailsFormat:     detailsFormat,
+			BatchSize:         batchSize,
+			MaxRecsWithErrors: &maxErrors,
+		})
+		result, err := v.Compare(ctx, args[0], args[1], qa.CompareOptions{
+			Keys:         keys,
+			IgnoreFields: ignoreFields,
+		})
+		if err != nil {
+			return runError(err)
+		}
+
+		fmt.Printf("%v records added, %v removed, %v changed and %v unchanged\n", result.Added, result.Removed, result.Changed, result.Unchanged)
+		fields := make([]string, 0, len(result.FieldChanges))
+		for field := range result.FieldChanges {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			fmt.Printf("  %v: %v changes\n", field, result.FieldChanges[field])
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(compareCmd)
+	compareCmd.Flags().StringSliceP("key", "k", nil, "Field joining the records of both files, can be specified multiple times for a composite key")
+	compareCmd.Flags().StringSlice("ignore-field", nil, "Field left out of the comparison, can be specified multiple times")
+	compareCmd.Flags().StringP("output-dir", "o", "reports", "Reports output directory that will contain the summary and detail outputs")
+	compareCmd.Flags().StringP("summary-file", "y", "summary", "The name of the summary file that will be saved")
+	compareCmd.Flags().String("details-format", qa.DetailsFormatJSON, "Details file format: json for a JSON array or ndjson for one record per line")
+	compareCmd.Flags().IntP("batch-size", "b", 10000, "Batch size to process records")
+	compareCmd.Flags().IntP("max", "m", -1, "Limit the max number of changed records being saved into the detail file. -1 means no limit.")
+}
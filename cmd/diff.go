@@ -0,0 +1,87 @@
+// Copyright © 2021 DataHen Canada Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/DataHenHQ/henqa/qa"
+	"github.com/spf13/cobra"
+)
+
+// diffCmd represents the diff command
+var diffCmd = &cobra.Command{
+	Use:   "diff <old-report-dir> <new-report-dir>",
+	Short: "Compares the summaries of two validations.",
+	Long: `Compares the error stats of each file and field.error_type of the summaries of two reports
+folders, reporting the new, resolved and changed error types with their percentage deltas.
+Files found in only one of the summaries are listed but their errors aren't compared.
+For example:
+henqa diff reports/yesterday reports/today
+henqa diff reports/yesterday reports/today --max-error-increase 1 -o diff.json
+
+Exit codes: 0 on success, 1 when an error percentage increased by more than --max-error-increase,
+2 on usage errors and 3 on I/O errors.
+`,
+	Args:         cobra.ExactArgs(2),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		summaryFile, err := cmd.Flags().GetString("summary-file")
+		if err != nil {
+			return usageError(err)
+		}
+		jsonFile, err := cmd.Flags().GetString("output")
+		if err != nil {
+			return usageError(err)
+		}
+		maxErrorIncrease, err := cmd.Flags().GetFloat64("max-error-increase")
+		if err != nil {
+			return usageError(err)
+		}
+
+		d, err := qa.DiffReports(args[0], args[1], summaryFile)
+		if err != nil {
+			return runError(err)
+		}
+		if err := qa.WriteSummaryDiff(os.Stdout, d); err != nil {
+			return runError(err)
+		}
+		if jsonFile != "" {
+			data, err := json.MarshalIndent(d, "", "  ")
+			if err != nil {
+				return runError(err)
+			}
+			if err := ioutil.WriteFile(jsonFile, data, 0644); err != nil {
+				return runError(err)
+			}
+			fmt.Println("Diff saved to", jsonFile)
+		}
+
+		if err := qa.CheckRegressions(d, maxErrorIncrease); err != nil {
+			return validationError(err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+	diffCmd.Flags().StringP("summary-file", "y", "summary", "The name of the summary file of both reports folders")
+	diffCmd.Flags().StringP("output", "o", "", "The JSON file to save the comparison to")
+	diffCmd.Flags().Float64("max-error-increase", -1, "Exit with a validation failure code when any error percentage increased by more than these percentage points. -1 means no limit.")
+}
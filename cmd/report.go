@@ -0,0 +1,119 @@
+// Copyright © 2021 DataHen Canada Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/DataHenHQ/henqa/qa"
+	"github.com/spf13/cobra"
+)
+
+// reportCmd represents the report command
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Renders the reports of a previous validation into other formats.",
+}
+
+// reportHTMLCmd represents the report html command
+var reportHTMLCmd = &cobra.Command{
+	Use:   "html <report-dir>",
+	Short: "Renders a reports folder into a self-contained HTML report.",
+	Long: `Renders the summary and details of a reports folder into a self-contained HTML report.
+For example:
+henqa report html ./reports
+henqa report html ./myreport -y mysummary -o myreport.html
+`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outDir := args[0]
+		summaryFile, err := cmd.Flags().GetString("summary-file")
+		if err != nil {
+			return usageError(err)
+		}
+		htmlFile, err := cmd.Flags().GetString("output")
+		if err != nil {
+			return usageError(err)
+		}
+		if htmlFile == "" {
+			htmlFile = filepath.Join(outDir, fmt.Sprintf("%v.html", summaryFile))
+		}
+		samples, err := cmd.Flags().GetInt("samples")
+		if err != nil {
+			return usageError(err)
+		}
+
+		err = qa.WriteHTMLReport(outDir, summaryFile, htmlFile, samples)
+		if err != nil {
+			return runError(err)
+		}
+		fmt.Println("HTML report saved to", htmlFile)
+		return nil
+	},
+}
+
+// reportJUnitCmd represents the report junit command
+var reportJUnitCmd = &cobra.Command{
+	Use:   "junit <report-dir>",
+	Short: "Renders a reports folder into a JUnit XML report for CI test reporting.",
+	Long: `Renders the summary of a reports folder into a JUnit XML report where each input file
+is a test suite and each field.error_type is a failing test case.
+For example:
+henqa report junit ./reports
+henqa report junit ./myreport -y mysummary -o junit.xml
+`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		outDir := args[0]
+		summaryFile, err := cmd.Flags().GetString("summary-file")
+		if err != nil {
+			return usageError(err)
+		}
+		xmlFile, err := cmd.Flags().GetString("output")
+		if err != nil {
+			return usageError(err)
+		}
+		if xmlFile == "" {
+			xmlFile = filepath.Join(outDir, fmt.Sprintf("%v.junit.xml", summaryFile))
+		}
+		values, err := cmd.Flags().GetInt("values")
+		if err != nil {
+			return usageError(err)
+		}
+
+		err = qa.WriteJUnitReport(outDir, summaryFile, xmlFile, values)
+		if err != nil {
+			return runError(err)
+		}
+		fmt.Println("JUnit report saved to", xmlFile)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reportCmd)
+	reportCmd.AddCommand(reportHTMLCmd)
+	reportHTMLCmd.Flags().StringP("summary-file", "y", "summary", "The name of the summary file of the reports folder")
+	reportHTMLCmd.Flags().StringP("output", "o", "", "The HTML file to save, defaults to <summary-file>.html within the reports folder")
+	reportHTMLCmd.Flags().Int("samples", qa.DefaultHTMLSamples, "The max number of failing records shown per field")
+
+	reportCmd.AddCommand(reportJUnitCmd)
+	reportJUnitCmd.Flags().StringP("summary-file", "y", "summary", "The name of the summary file of the reports folder")
+	reportJUnitCmd.Flags().StringP("output", "o", "", "The XML file to save, defaults to <summary-file>.junit.xml within the reports folder")
+	reportJUnitCmd.Flags().Int("values", qa.DefaultJUnitValues, "The max number of offending values shown per failing test case")
+}
@@ -15,6 +15,7 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -25,6 +26,36 @@ import (
 
 var cfgFile string
 
+// Process exit codes
+const (
+	ExitOK               = 0
+	ExitValidationFailed = 1
+	ExitUsage            = 2
+	ExitError            = 3
+)
+
+// exitError is an error that carries the process exit code to use
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func usageError(err error) error {
+	return &exitError{code: ExitUsage, err: err}
+}
+
+func runError(err error) error {
+	return &exitError{code: ExitError, err: err}
+}
+
+func validationError(err error) error {
+	return &exitError{code: ExitValidationFailed, err: err}
+}
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Use:   "henqa",
@@ -34,15 +65,29 @@ var rootCmd = &cobra.Command{
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	//	Run: func(cmd *cobra.Command, args []string) { },
+	SilenceErrors: true,
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
+// Errors returned by cobra itself, such as unknown flags, exit as usage errors.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// exitCode is the process exit code of the error returned by a command
+func exitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	var ee *exitError
+	if errors.As(err, &ee) {
+		return ee.code
 	}
+	return ExitUsage
 }
 
 func init() {
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// runCommand runs henqa with the arguments and returns its exit code. Flags
+// keep their values across runs, so each run sets the flags it depends on.
+func runCommand(t *testing.T, args ...string) int {
+	t.Helper()
+	rootCmd.SetArgs(args)
+	rootCmd.SetOut(ioutil.Discard)
+	rootCmd.SetErr(ioutil.Discard)
+	return exitCode(rootCmd.Execute())
+}
+
+func TestMaxErrorIncreaseExitCode(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"schema.json":         `{"type": "object", "required": ["id"], "properties": {"id": {"type": "integer"}}}`,
+		"old/products.ndjson": "{\"id\": 1}\n{\"id\": 2}\n{\"id\": 3}\n{\"id\": \"x\"}\n",
+		"new/products.ndjson": "{\"id\": 1}\n{\"id\": \"y\"}\n{\"id\": 3}\n{\"id\": \"x\"}\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := func(name string) string { return filepath.Join(dir, name) }
+
+	if code := runCommand(t, "validate", p("old"), "-s", p("schema.json"), "-o", p("old-reports")); code != ExitOK {
+		t.Fatalf("got exit code %v validating the baseline, want %v", code, ExitOK)
+	}
+
+	// the invalid ids went from 25% to 50% of the records
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{
+			name: "increase over the threshold",
+			args: []string{"validate", p("new"), "-s", p("schema.json"), "-o", p("new-reports"), "--baseline", p("old-reports"), "--max-error-increase", "10"},
+			want: ExitValidationFailed,
+		},
+		{
+			name: "increase within the threshold",
+			args: []string{"validate", p("new"), "-s", p("schema.json"), "-o", p("new-reports"), "--baseline", p("old-reports"), "--max-error-increase", "25"},
+			want: ExitOK,
+		},
+		{
+			name: "no threshold",
+			args: []string{"validate", p("new"), "-s", p("schema.json"), "-o", p("new-reports"), "--baseline", p("old-reports"), "--max-error-increase", "-1"},
+			want: ExitOK,
+		},
+		{
+			name: "threshold without baseline",
+			args: []string{"validate", p("new"), "-s", p("schema.json"), "-o", p("new-reports"), "--baseline", "", "--max-error-increase", "10"},
+			want: ExitUsage,
+		},
+		{
+			name: "missing baseline",
+			args: []string{"validate", p("new"), "-s", p("schema.json"), "-o", p("new-reports"), "--baseline", p("missing-reports"), "--max-error-increase", "10"},
+			want: ExitError,
+		},
+		{
+			name: "diff over the threshold",
+			args: []string{"diff", p("old-reports"), p("new-reports"), "--max-error-increase", "10"},
+			want: ExitValidationFailed,
+		},
+		{
+			name: "diff within the threshold",
+			args: []string{"diff", p("old-reports"), p("new-reports"), "--max-error-increase", "25"},
+			want: ExitOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := runCommand(t, tt.args...); code != tt.want {
+				t.Errorf("got exit code %v, want %v", code, tt.want)
+			}
+		})
+	}
+}
@@ -15,7 +15,12 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/DataHenHQ/henqa/qa"
 	"github.com/spf13/cobra"
@@ -26,75 +31,457 @@ var validateCmd = &cobra.Command{
 	Use:   "validate",
 	Short: "Validates the input data files using JSON schema files and creates reports.",
 	Long: `Validates the input data files using JSON schema files and creates reports.
-If multiple schema files is supplied, the later will merge with the former using "JSON Merge Patch" method.
+Input files can be .csv, .tsv, .json, .njson, .parquet or .xlsx files. Compressed .gz, .zst and .bz2 files are
+decompressed on the fly and reported by their inner file name. The files inside .zip, .tar and .tar.gz
+archives are validated like the files of a directory, and reported as archive.zip/inner/path.json.
+The reports of the files of a directory are named after their path within it, below the name of the
+directory when several inputs are given, so they match across runs for --baseline and henqa diff.
+The files of directories and archives can be filtered with --include and --exclude glob patterns
+relative to the directory or archive, dotfiles are skipped unless --hidden is set, and the patterns of
+a .henqaignore file are ignored in its directory and subdirectories, the same way as a .gitignore.
+Unsupported files of directories and archives, such as a README, are ignored.
+Use - as input to read the records from stdin, with --input-format to set their format and
+--stdin-name to name their reports.
+CSV files are comma delimited UTF-8 files with a header row by default, and .tsv files are tab
+delimited. Use the --csv-* flags to read other dialects, or a --csv-dialects-file to set the
+dialect of the files whose report path matches a pattern, such as:
+[{"pattern": "eu/**/*.csv", "delimiter": ";", "encoding": "latin1"}]
+The records of each sheet of .xlsx files are validated as a collection named after the sheet, with the
+column names of the first non empty row or --xlsx-header-row. Use --xlsx-sheet to select the sheets.
+CSV values are strings, use --coerce to convert them to the integer, number, boolean, null, object or
+array types declared by the schema properties. Empty values become null when allowed, and missing
+otherwise. Values that cannot be converted are reported as coercion_failed errors.
+If multiple schema files is supplied, the later will merge with the former using the --merge-strategy:
+  merge-patch  "JSON Merge Patch" method (default), arrays such as required are replaced
+  deep         objects are merged recursively and arrays are extended with the later items,
+               an object replacing another value or replaced by one is an error
+  json-patch   the later files are "JSON Patch" operations applied to the first schema
+  allof        the records must be valid against every schema, composed with allOf
+The $ref to local schemas are resolved by $id or by path, relative to the referencing schema or to a
+--schema-dir, and bundled into the schema definitions.
+Schemas can be http(s) URLs, cached in --schema-cache-dir and revalidated with their ETag on each run.
+Pin a remote schema to its SHA-256 checksum with a #sha256=<hex> fragment, and use --offline to only
+use the cached schemas. The remote schemas referenced by $ref are bundled and cached the same way.
+Records of a collection can be validated against their own schema by using --collection-schema or
+a --collection-schema-file mapping, other collections will use the merged schema.
+Use --unique-key to report the records whose key fields have the same values as an earlier record of
+the file, or of any file with --unique-across-files, as duplicate_key errors.
+With --parallel, which of two records of different files is reported as the duplicate depends on
+the order the files are validated in.
+Use --profile to compute the statistics of the fields of each file in the same pass: fill rate, null
+count, distinct count estimate, min, max and mean of numbers, length distribution of strings and the
+most frequent values, saved as profile/<file>.json next to the summary and details.
+Use --baseline to compare the summary against the reports folder of a previous validation, reporting
+the new, resolved and changed error types, and --max-error-increase to fail on regressions.
 For example:
 henqa validate file1.csv file2.csv -s schema1.json -s schema2.json
 henqa validate ./dir1 ./dir2 -s schema1.jos -s schema2.json -r myreport
+henqa validate ./exports --include '**/*.json' --exclude '**/tmp/**' -s schema1.json
+zcat dump.gz | jq -c '.[]' | henqa validate - --input-format ndjson --stdin-name dump.ndjson -s schema1.json
+henqa validate people.csv --csv-delimiter ';' --csv-encoding latin1 --csv-header name,age -s person.json
+henqa validate items.json -s 'https://example.com/schemas/item.json#sha256=9f86d0...' --offline
+henqa validate export.json --collection-schema products=products.json --collection-schema reviews=reviews.json
+henqa validate products.json -s product.json --unique-key url
+henqa validate ./new-scraper -s schema1.json --profile --profile-top 20
+henqa validate ./today -s schema1.json -o reports/today --baseline reports/yesterday --max-error-increase 1
+
+Exit codes: 0 on success, 1 when the validation fails the --fail-on-errors, --max-error-percent or
+--max-error-increase thresholds, 2 on usage errors and 3 on I/O, schema or workflow errors, including
+missing or unreadable inputs, input files that cannot be validated and inputs without any file to
+validate.
 `,
-	Args: cobra.MinimumNArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
+	Args:         validateArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
 		var (
-			schemas     = []string{}
-			outDir      = ""
-			maxErrors   = -1
-			batchSize   = 0
-			wfname      = ""
-			summaryFile = ""
-			err         error
+			schemas         = []string{}
+			colSchemas      = map[string][]string{}
+			outDir          = ""
+			maxErrors       = -1
+			batchSize       = 0
+			wfname          = ""
+			summaryFile     = ""
+			failOnErrors    = false
+			maxErrorPercent = -1.0
+			err             error
 		)
 		schemas, err = cmd.Flags().GetStringSlice("schema")
 		if err != nil {
-			fmt.Errorf("Gotten error: %v\n", err.Error())
-			return
+			return usageError(err)
+		}
+		if err := qa.ValidateSchemaURLs(schemas); err != nil {
+			return usageError(err)
+		}
+		schemaCacheDir, err := cmd.Flags().GetString("schema-cache-dir")
+		if err != nil {
+			return usageError(err)
+		}
+		offline, err := cmd.Flags().GetBool("offline")
+		if err != nil {
+			return usageError(err)
+		}
+		mergeStrategy, err := cmd.Flags().GetString("merge-strategy")
+		if err != nil {
+			return usageError(err)
+		}
+		if err := qa.ValidateMergeStrategy(mergeStrategy); err != nil {
+			return usageError(err)
+		}
+		schemaDirs, err := cmd.Flags().GetStringSlice("schema-dir")
+		if err != nil {
+			return usageError(err)
+		}
+		colSchemaPairs, err := cmd.Flags().GetStringArray("collection-schema")
+		if err != nil {
+			return usageError(err)
+		}
+		colSchemaFile, err := cmd.Flags().GetString("collection-schema-file")
+		if err != nil {
+			return usageError(err)
+		}
+		colSchemas, err = qa.ParseCollectionSchemas(colSchemaPairs, colSchemaFile)
+		if err != nil {
+			return usageError(err)
+		}
+		for _, colFiles := range colSchemas {
+			if err := qa.ValidateSchemaURLs(colFiles); err != nil {
+				return usageError(err)
+			}
 		}
 		outDir, err = cmd.Flags().GetString("output-dir")
 		if err != nil {
-			fmt.Errorf("Gotten error: %v\n", err.Error())
-			return
+			return usageError(err)
 		}
 		summaryFile, err = cmd.Flags().GetString("summary-file")
 		if err != nil {
-			fmt.Errorf("Gotten error: %v\n", err.Error())
-			return
+			return usageError(err)
 		}
 		batchSize, err = cmd.Flags().GetInt("batch-size")
 		if err != nil {
-			fmt.Errorf("Gotten error: %v\n", err.Error())
-			return
+			return usageError(err)
 		}
 		if batchSize < 1 {
-			fmt.Errorf("Gotten error: %v\n", "Batch size must be at least 1")
-			return
+			return usageError(errors.New("Batch size must be at least 1"))
 		}
 
 		maxErrors, err = cmd.Flags().GetInt("max")
 		if err != nil {
-			fmt.Errorf("Gotten error: %v\n", err.Error())
-			return
+			return usageError(err)
 		}
 
 		wfname, err = cmd.Flags().GetString("workflow")
 		if err != nil {
-			fmt.Errorf("Gotten error: %v\n", err.Error())
-			return
+			return usageError(err)
+		}
+
+		varPairs, err := cmd.Flags().GetStringArray("var")
+		if err != nil {
+			return usageError(err)
+		}
+		varsFile, err := cmd.Flags().GetString("vars-file")
+		if err != nil {
+			return usageError(err)
+		}
+		vars, err := qa.ParseVars(varPairs, varsFile)
+		if err != nil {
+			return usageError(err)
+		}
+
+		failOnErrors, err = cmd.Flags().GetBool("fail-on-errors")
+		if err != nil {
+			return usageError(err)
+		}
+		maxErrorPercent, err = cmd.Flags().GetFloat64("max-error-percent")
+		if err != nil {
+			return usageError(err)
+		}
+		baseline, err := cmd.Flags().GetString("baseline")
+		if err != nil {
+			return usageError(err)
+		}
+		maxErrorIncrease, err := cmd.Flags().GetFloat64("max-error-increase")
+		if err != nil {
+			return usageError(err)
+		}
+		if maxErrorIncrease >= 0 && baseline == "" {
+			return usageError(errors.New("--max-error-increase requires a --baseline"))
+		}
+
+		formats, err := cmd.Flags().GetStringSlice("format")
+		if err != nil {
+			return usageError(err)
+		}
+		for _, format := range formats {
+			if format != "json" && format != "html" && format != "junit" {
+				return usageError(fmt.Errorf("unknown report format %q", format))
+			}
+		}
+
+		detailsFormat, err := cmd.Flags().GetString("details-format")
+		if err != nil {
+			return usageError(err)
+		}
+		if detailsFormat != qa.DetailsFormatJSON && detailsFormat != qa.DetailsFormatNDJSON {
+			return usageError(fmt.Errorf("unknown details format %q", detailsFormat))
+		}
+
+		parallel, err := cmd.Flags().GetInt("parallel")
+		if err != nil {
+			return usageError(err)
+		}
+		if parallel < 1 {
+			return usageError(errors.New("Parallel must be at least 1"))
+		}
+
+		includes, err := cmd.Flags().GetStringArray("include")
+		if err != nil {
+			return usageError(err)
+		}
+		excludes, err := cmd.Flags().GetStringArray("exclude")
+		if err != nil {
+			return usageError(err)
+		}
+		if err := qa.ValidatePatterns(append(includes, excludes...)); err != nil {
+			return usageError(err)
+		}
+		noRecursive, err := cmd.Flags().GetBool("no-recursive")
+		if err != nil {
+			return usageError(err)
+		}
+		hidden, err := cmd.Flags().GetBool("hidden")
+		if err != nil {
+			return usageError(err)
+		}
+
+		inputFormat, err := cmd.Flags().GetString("input-format")
+		if err != nil {
+			return usageError(err)
+		}
+		if err := qa.ValidateInputFormat(inputFormat); err != nil {
+			return usageError(err)
+		}
+		stdinName, err := cmd.Flags().GetString("stdin-name")
+		if err != nil {
+			return usageError(err)
+		}
+
+		csvDialect, err := csvDialectFromFlags(cmd)
+		if err != nil {
+			return usageError(err)
+		}
+		csvDialectsFile, err := cmd.Flags().GetString("csv-dialects-file")
+		if err != nil {
+			return usageError(err)
+		}
+		csvDialects, err := qa.ParseCSVDialects(csvDialectsFile)
+		if err != nil {
+			return usageError(err)
+		}
+
+		profile, err := cmd.Flags().GetBool("profile")
+		if err != nil {
+			return usageError(err)
+		}
+		profileTopN, err := cmd.Flags().GetInt("profile-top")
+		if err != nil {
+			return usageError(err)
+		}
+		if profileTopN < 1 {
+			return usageError(errors.New("Profile top values must be at least 1"))
+		}
+
+		uniqueKey, err := cmd.Flags().GetStringSlice("unique-key")
+		if err != nil {
+			return usageError(err)
+		}
+		uniqueAcrossFiles, err := cmd.Flags().GetBool("unique-across-files")
+		if err != nil {
+			return usageError(err)
+		}
+		if uniqueAcrossFiles && len(uniqueKey) == 0 {
+			return usageError(errors.New("--unique-across-files requires a --unique-key"))
+		}
+
+		coerce, err := cmd.Flags().GetBool("coerce")
+		if err != nil {
+			return usageError(err)
+		}
+		coerceArraySeparator, err := cmd.Flags().GetString("coerce-array-separator")
+		if err != nil {
+			return usageError(err)
+		}
+		if coerceArraySeparator == "" {
+			return usageError(errors.New("Coerce array separator cannot be empty"))
 		}
 
-		err = qa.Validate(args, schemas, wfname, outDir, summaryFile, batchSize, maxErrors)
+		xlsxSheets, err := cmd.Flags().GetStringSlice("xlsx-sheet")
 		if err != nil {
-			fmt.Errorf("Gotten error: %v\n", err.Error())
-			return
+			return usageError(err)
 		}
+		xlsxHeaderRow, err := cmd.Flags().GetInt("xlsx-header-row")
+		if err != nil {
+			return usageError(err)
+		}
+		if xlsxHeaderRow < 0 {
+			return usageError(errors.New("XLSX header row cannot be negative"))
+		}
+
+		timeout, err := cmd.Flags().GetDuration("timeout")
+		if err != nil {
+			return usageError(err)
+		}
+
+		// stop cleanly on interrupt or when the timeout is reached
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		v := qa.NewValidator(qa.Options{
+			Inputs:               args,
+			InputFormat:          inputFormat,
+			StdinName:            stdinName,
+			CSVDialect:           csvDialect,
+			CSVDialects:          csvDialects,
+			XLSXSheets:           xlsxSheets,
+			XLSXHeaderRow:        xlsxHeaderRow,
+			Coerce:               coerce,
+			CoerceArraySeparator: coerceArraySeparator,
+			Include:              includes,
+			Exclude:              excludes,
+			NoRecursive:          noRecursive,
+			Hidden:               hidden,
+			Schemas:              schemas,
+			SchemaDirs:           schemaDirs,
+			SchemaCacheDir:       schemaCacheDir,
+			Offline:              offline,
+			MergeStrategy:        mergeStrategy,
+			CollectionSchemas:    colSchemas,
+			Profile:              profile,
+			ProfileTopN:          profileTopN,
+			UniqueKey:            uniqueKey,
+			UniqueKeyAcrossFiles: uniqueAcrossFiles,
+			Workflow:             wfname,
+			Vars:                 vars,
+			OutDir:               outDir,
+			SummaryFile:          summaryFile,
+			Baseline:             baseline,
+			Formats:              formats,
+			DetailsFormat:        detailsFormat,
+			BatchSize:            batchSize,
+			Parallel:             parallel,
+			MaxRecsWithErrors:    &maxErrors,
+		})
+		result, err := v.Run(ctx)
+		if err != nil {
+			if errors.Is(err, qa.ErrNoSchemaOrWorkflow) {
+				return usageError(err)
+			}
+			return runError(err)
+		}
+
+		// gate the validation result with the thresholds
+		err = qa.CheckThresholds(result, qa.Thresholds{
+			FailOnErrors:     failOnErrors,
+			MaxErrorPercent:  maxErrorPercent,
+			MaxErrorIncrease: maxErrorIncrease,
+		})
+		if err != nil {
+			return validationError(err)
+		}
+
+		return nil
 	},
 }
 
 var schemas string
 
+// csvDialectFromFlags builds the default CSV dialect from the --csv-* flags
+func csvDialectFromFlags(cmd *cobra.Command) (d qa.CSVDialect, err error) {
+	if d.Delimiter, err = cmd.Flags().GetString("csv-delimiter"); err != nil {
+		return d, err
+	}
+	if d.Quote, err = cmd.Flags().GetString("csv-quote"); err != nil {
+		return d, err
+	}
+	if d.Comment, err = cmd.Flags().GetString("csv-comment"); err != nil {
+		return d, err
+	}
+	if d.Encoding, err = cmd.Flags().GetString("csv-encoding"); err != nil {
+		return d, err
+	}
+	if d.KeepBOM, err = cmd.Flags().GetBool("csv-keep-bom"); err != nil {
+		return d, err
+	}
+	if d.Header, err = cmd.Flags().GetStringSlice("csv-header"); err != nil {
+		return d, err
+	}
+	return d, d.Validate()
+}
+
+// validateArgs requires at least one input, reading stdin at most once
+func validateArgs(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 {
+		return errors.New("requires at least 1 input file or directory, or - to read stdin")
+	}
+	stdinCount := 0
+	for _, arg := range args {
+		if arg == qa.StdinInput {
+			stdinCount++
+		}
+	}
+	if stdinCount > 1 {
+		return errors.New("stdin can only be read once")
+	}
+	return nil
+}
+
 func init() {
 	rootCmd.AddCommand(validateCmd)
 	validateCmd.Flags().StringSliceP("schema", "s", nil, "JSON schema file to use if multiple is specified, the latter will override the former")
+	validateCmd.Flags().String("merge-strategy", qa.MergeStrategyMergePatch, "How multiple schema files are combined: merge-patch, deep, json-patch or allof")
+	validateCmd.Flags().StringSlice("schema-dir", nil, "Directory of the schemas that $ref can point to by $id or relative path, can be specified multiple times")
+	validateCmd.Flags().String("schema-cache-dir", "", "Directory caching the remote schemas, defaults to henqa/schemas in the user cache directory")
+	validateCmd.Flags().Bool("offline", false, "Only use the cached copies of the remote schemas")
+	validateCmd.Flags().StringArray("collection-schema", nil, "JSON schema file to use for a collection as collection=schema.json, can be specified multiple times and the latter will override the former")
+	validateCmd.Flags().String("collection-schema-file", "", "JSON or YAML file mapping collection names to their JSON schema files")
+	validateCmd.Flags().StringArray("include", nil, "Glob pattern of the files to validate inside the input directories and archives, such as '**/*.json', can be specified multiple times")
+	validateCmd.Flags().StringArray("exclude", nil, "Glob pattern of the files and directories to skip, such as '**/tmp/**', can be specified multiple times")
+	validateCmd.Flags().Bool("no-recursive", false, "Only validate the files directly inside the input directories")
+	validateCmd.Flags().Bool("hidden", false, "Also validate dotfiles and the files inside dot directories")
+	validateCmd.Flags().String("input-format", "", "Format of the records read from stdin: csv, json or ndjson. Defaults to the --stdin-name extension, or else ndjson.")
+	validateCmd.Flags().String("stdin-name", "stdin", "Name of the reports of the records read from stdin")
+	validateCmd.Flags().String("csv-delimiter", "", "CSV field delimiter, such as ';' or '\\t'. Defaults to ',' and to tabs for .tsv files.")
+	validateCmd.Flags().String("csv-quote", "", "CSV quote character, defaults to '\"'. none reads the quotes as part of the values.")
+	validateCmd.Flags().String("csv-comment", "", "CSV comment character starting the lines to skip, such as '#'")
+	validateCmd.Flags().String("csv-encoding", "", "CSV character encoding, such as latin1, windows-1252 or utf-16le. Defaults to utf-8.")
+	validateCmd.Flags().Bool("csv-keep-bom", false, "Keep the byte order mark at the start of CSV files instead of stripping it")
+	validateCmd.Flags().StringSlice("csv-header", nil, "Column names of CSV files without a header row, such as name,age")
+	validateCmd.Flags().String("csv-dialects-file", "", "JSON or YAML file listing the CSV dialects of the files whose report path matches a pattern")
+	validateCmd.Flags().StringSlice("xlsx-sheet", nil, "Name or 1-based index of the .xlsx sheets to validate, all sheets by default")
+	validateCmd.Flags().Int("xlsx-header-row", 0, "1-based row of the column names in .xlsx sheets, 0 detects the first non empty row")
+	validateCmd.Flags().Bool("profile", false, "Save the statistics of the fields of each file into the profile folder of the reports")
+	validateCmd.Flags().Int("profile-top", qa.DefaultProfileTopN, "The number of most frequent values profiled per field")
+	validateCmd.Flags().StringSlice("unique-key", nil, "Field that must be unique across the records of a file, specify several fields for a composite key")
+	validateCmd.Flags().Bool("unique-across-files", false, "Check the --unique-key across all the input files")
+	validateCmd.Flags().Bool("coerce", false, "Convert the CSV values to the types declared by the schema before validation")
+	validateCmd.Flags().String("coerce-array-separator", qa.DefaultCoerceArraySeparator, "Separator of the items of CSV values coerced to arrays")
 	validateCmd.Flags().StringP("output-dir", "o", "reports", "Reports output directory that will contain the summary and detail outputs")
 	validateCmd.Flags().StringP("summary-file", "y", "summary", "The name of the summary file that will be saved")
+	validateCmd.Flags().StringSlice("format", nil, "Additional report formats to write next to the JSON reports: html, junit")
+	validateCmd.Flags().String("details-format", qa.DetailsFormatJSON, "Details file format: json for a JSON array or ndjson for one record per line")
 	validateCmd.Flags().IntP("batch-size", "b", 10000, "Batch size to process records")
 	validateCmd.Flags().IntP("max", "m", -1, "Limit the max number of errors being saved into the detail file. This is meant to make the file smaller. -1 means no limit.")
 	validateCmd.Flags().StringP("workflow", "w", "", "The name of the workflow that will be executed")
+	validateCmd.Flags().StringArray("var", nil, "Workflow variable as key=value, can be specified multiple times")
+	validateCmd.Flags().String("vars-file", "", "JSON or YAML file containing the workflow variables")
+	validateCmd.Flags().IntP("parallel", "p", 1, "Number of files to validate at the same time. Workflows that keep gvars across files are always validated sequentially.")
+	validateCmd.Flags().Duration("timeout", 0, "Stop the validation after this duration, such as 30m, keeping the partial reports. 0 means no timeout.")
+	validateCmd.Flags().Bool("fail-on-errors", false, "Exit with a validation failure code when any error is found")
+	validateCmd.Flags().Float64("max-error-percent", -1, "Exit with a validation failure code when any error percentage, per file or overall, is greater than this. -1 means no limit.")
+	validateCmd.Flags().String("baseline", "", "Reports folder of a previous validation to compare the summary against")
+	validateCmd.Flags().Float64("max-error-increase", -1, "Exit with a validation failure code when any error percentage increased by more than these percentage points over the --baseline. -1 means no limit.")
 }
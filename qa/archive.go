@@ -0,0 +1,318 @@
+package qa
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/DataHenHQ/datahen/records"
+)
+
+// Archive formats of input files
+const (
+	archiveNone  = ""
+	archiveZip   = "zip"
+	archiveTar   = "tar"
+	archiveTarGz = "tar.gz"
+)
+
+// archiveFormat returns the archive format of the file by its extension
+func archiveFormat(f string) string {
+	name := strings.ToLower(f)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return archiveZip
+	case strings.HasSuffix(name, ".tar"):
+		return archiveTar
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return archiveTarGz
+	}
+	return archiveNone
+}
+
+// listArchiveMembers enumerates the regular files of the archive as paths
+// below the archive, like archive.zip/inner/path.json
+func listArchiveMembers(archive string) (files []string, err error) {
+	switch archiveFormat(archive) {
+	case archiveZip:
+		zr, err := zip.OpenReader(archive)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+
+		for _, zf := range zr.File {
+			if zf.FileInfo().IsDir() {
+				continue
+			}
+			if name, ok := cleanMemberName(zf.Name); ok {
+				files = append(files, filepath.Join(archive, filepath.FromSlash(name)))
+			}
+		}
+		return files, nil
+	case archiveTar, archiveTarGz:
+		err = walkTar(archive, func(hdr *tar.Header, r io.Reader) (stop bool, err error) {
+			if hdr.Typeflag != tar.TypeReg {
+				return false, nil
+			}
+			if name, ok := cleanMemberName(hdr.Name); ok {
+				files = append(files, filepath.Join(archive, filepath.FromSlash(name)))
+			}
+			return false, nil
+		})
+		return files, err
+	}
+	return nil, fmt.Errorf("%s is not a .zip, .tar or .tar.gz archive", archive)
+}
+
+// cleanMemberName normalizes the name of an archive member, rejecting the
+// ones pointing outside of the archive
+func cleanMemberName(name string) (cleaned string, ok bool) {
+	cleaned = path.Clean(strings.TrimPrefix(name, "./"))
+	if cleaned == "." || path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", false
+	}
+	return cleaned, true
+}
+
+// splitArchivePath splits a path listed by listArchiveMembers into the
+// archive file and the member name within it
+func splitArchivePath(f string) (archive string, member string, ok bool) {
+	if fileExists(f) {
+		return "", "", false
+	}
+	for dir := filepath.Dir(f); dir != filepath.Dir(dir) && dir != "."; dir = filepath.Dir(dir) {
+		if archiveFormat(dir) == archiveNone || !fileExists(dir) {
+			continue
+		}
+		rel, err := filepath.Rel(dir, f)
+		if err != nil {
+			return "", "", false
+		}
+		return dir, filepath.ToSlash(rel), true
+	}
+	return "", "", false
+}
+
+// walkTar calls fn with every entry of the tar archive until it stops
+func walkTar(archive string, fn func(hdr *tar.Header, r io.Reader) (stop bool, err error)) (err error) {
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if archiveFormat(archive) == archiveTarGz {
+		gr, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		r = gr
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		stop, err := fn(hdr, tr)
+		if err != nil || stop {
+			return err
+		}
+	}
+}
+
+// tarCursor reads the members of a tar archive in a single pass over its
+// stream, as long as they are requested in the order of the archive. A member
+// behind the cursor reopens the archive from the start.
+type tarCursor struct {
+	mu      sync.Mutex
+	archive string
+	file    *os.File
+	gr      *gzip.Reader
+	tr      *tar.Reader
+}
+
+func (c *tarCursor) open() (err error) {
+	c.file, err = os.Open(c.archive)
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = c.file
+	if archiveFormat(c.archive) == archiveTarGz {
+		c.gr, err = gzip.NewReader(c.file)
+		if err != nil {
+			c.close()
+			return err
+		}
+		r = c.gr
+	}
+	c.tr = tar.NewReader(r)
+	return nil
+}
+
+func (c *tarCursor) close() {
+	if c.gr != nil {
+		c.gr.Close()
+	}
+	if c.file != nil {
+		c.file.Close()
+	}
+	c.file, c.gr, c.tr = nil, nil, nil
+}
+
+// seek moves the cursor forward to the member, found is false at the end of
+// the archive
+func (c *tarCursor) seek(member string) (found bool, err error) {
+	for {
+		hdr, err := c.tr.Next()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if name, ok := cleanMemberName(hdr.Name); ok && name == member && hdr.Typeflag == tar.TypeReg {
+			return true, nil
+		}
+	}
+}
+
+// withMember passes the stream of the member to fn, holding the cursor until fn returns
+func (c *tarCursor) withMember(member string, fn func(r io.Reader) error) (err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fromStart := c.tr == nil
+	for {
+		if c.tr == nil {
+			if err := c.open(); err != nil {
+				return err
+			}
+		}
+		found, err := c.seek(member)
+		if err != nil {
+			c.close()
+			return err
+		}
+		if found {
+			return fn(c.tr)
+		}
+
+		// the member may be behind the cursor
+		c.close()
+		if fromStart {
+			return fmt.Errorf("%s not found in %s", member, c.archive)
+		}
+		fromStart = true
+	}
+}
+
+// tarCursors keeps a cursor per tar archive, so the members listed from an
+// archive are validated in one pass over it
+type tarCursors struct {
+	mu      sync.Mutex
+	cursors map[string]*tarCursor
+}
+
+func (tc *tarCursors) get(archive string) *tarCursor {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if tc.cursors == nil {
+		tc.cursors = map[string]*tarCursor{}
+	}
+	c := tc.cursors[archive]
+	if c == nil {
+		c = &tarCursor{archive: archive}
+		tc.cursors[archive] = c
+	}
+	return c
+}
+
+// Close closes the archives held open by the cursors
+func (tc *tarCursors) Close() {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	for archive, c := range tc.cursors {
+		c.mu.Lock()
+		c.close()
+		c.mu.Unlock()
+		delete(tc.cursors, archive)
+	}
+}
+
+// withArchiveMember opens the member of the archive and passes its
+// decompressed stream to fn. Tar members are read through their cursor.
+func withArchiveMember(tc *tarCursors, archive string, member string, fn func(r io.Reader) error) (err error) {
+	found := false
+	open := func(r io.Reader) error {
+		found = true
+		rc, _, err := decompressReader(r)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		return fn(rc)
+	}
+
+	switch archiveFormat(archive) {
+	case archiveZip:
+		err = withZipMember(archive, member, open)
+	case archiveTar, archiveTarGz:
+		err = tc.get(archive).withMember(member, open)
+	}
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("%s not found in %s", member, archive)
+	}
+	return nil
+}
+
+func withZipMember(archive string, member string, fn func(r io.Reader) error) (err error) {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, zf := range zr.File {
+		if name, ok := cleanMemberName(zf.Name); !ok || name != member || zf.FileInfo().IsDir() {
+			continue
+		}
+		r, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		return fn(r)
+	}
+	return nil
+}
+
+// archiveMemberProcessor streams the member of the archive into the reader
+// processor
+func archiveMemberProcessor(tc *tarCursors, archive string, member string, process processReaderFn) processFileStreamFn {
+	return func(filename string, batchSize int, configReaderFn records.ConfigReaderFn, validateFn records.ValidateFn, validatePreRecords records.ValidateHeadersFn) (err error) {
+		return withArchiveMember(tc, archive, member, func(r io.Reader) error {
+			return process(r, batchSize, configReaderFn, validateFn, validatePreRecords)
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package qa
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/xitongsys/parquet-go/writer"
+	"github.com/xuri/excelize/v2"
+)
+
+// testArchiveMember is a member of a test archive, in the order of the archive
+type testArchiveMember struct {
+	name    string
+	content string
+	dir     bool
+}
+
+// zipString builds a zip archive of the members
+func zipString(t *testing.T, members []testArchiveMember) string {
+	t.Helper()
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for _, m := range members {
+		name := m.name
+		if m.dir {
+			name += "/"
+		}
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(m.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+// tarString builds a tar archive of the members, gzipped when required
+func tarString(t *testing.T, members []testArchiveMember, gz bool) string {
+	t.Helper()
+	var b bytes.Buffer
+	var w io.Writer = &b
+	var zw *gzip.Writer
+	if gz {
+		zw = gzip.NewWriter(&b)
+		w = zw
+	}
+	tw := tar.NewWriter(w)
+	for _, m := range members {
+		hdr := &tar.Header{Name: m.name, Mode: 0644, Size: int64(len(m.content)), Typeflag: tar.TypeReg}
+		if m.dir {
+			hdr = &tar.Header{Name: m.name + "/", Mode: 0755, Typeflag: tar.TypeDir}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if !m.dir {
+			if _, err := tw.Write([]byte(m.content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return b.String()
+}
+
+// xlsxString builds an Excel file with a sheet per name, renaming the default sheet
+func xlsxString(t *testing.T, sheets map[string][][]interface{}) string {
+	t.Helper()
+	f := excelize.NewFile()
+	names := make([]string, 0, len(sheets))
+	for name := range sheets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		if i == 0 {
+			f.SetSheetName(f.GetSheetName(0), name)
+		} else {
+			f.NewSheet(name)
+		}
+		for r, row := range sheets[name] {
+			cell, err := excelize.CoordinatesToCellName(1, r+1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := f.SetSheetRow(name, cell, &row); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	b, err := f.WriteToBuffer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+// parquetString builds a parquet file of the JSON rows with the parquet-go JSON schema
+func parquetString(t *testing.T, schema string, rows ...string) string {
+	t.Helper()
+	var b bytes.Buffer
+	pw, err := writer.NewJSONWriterFromWriter(schema, &b, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if err := pw.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pw.WriteStop(); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+// testParquetSchema is the parquet schema of rows with an integer id
+const testParquetSchema = `{
+	"Tag": "name=parquet_go_root, repetitiontype=REQUIRED",
+	"Fields": [
+		{"Tag": "name=id, type=INT64, repetitiontype=OPTIONAL"},
+		{"Tag": "name=name, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"}
+	]
+}`
+
+var testArchiveMembers = []testArchiveMember{
+	{name: "inner", dir: true},
+	{name: "./products.ndjson", content: "{\"id\": 1}\n{\"id\": 2}\n"},
+	{name: "inner/items.csv", content: "id,name\n1,a\n2,b\n3,c\n"},
+	{name: "../escape.json", content: "[]"},
+	{name: "inner/readme.txt", content: "not data"},
+}
+
+func TestListArchiveMembers(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"a.zip":    zipString(t, testArchiveMembers),
+		"a.tar":    tarString(t, testArchiveMembers, false),
+		"a.tar.gz": tarString(t, testArchiveMembers, true),
+	})
+
+	for _, name := range []string{"a.zip", "a.tar", "a.tar.gz"} {
+		t.Run(name, func(t *testing.T) {
+			archive := filepath.Join(dir, name)
+			members, err := listArchiveMembers(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []string{
+				filepath.Join(archive, "products.ndjson"),
+				filepath.Join(archive, "inner", "items.csv"),
+				filepath.Join(archive, "inner", "readme.txt"),
+			}
+			if !reflect.DeepEqual(members, want) {
+				t.Errorf("got members %v, want %v", members, want)
+			}
+
+			got, member, ok := splitArchivePath(want[1])
+			if !ok || got != archive || member != "inner/items.csv" {
+				t.Errorf("got archive %v and member %v, want %v and inner/items.csv", got, member, archive)
+			}
+		})
+	}
+}
+
+func TestTarCursor(t *testing.T) {
+	dir := t.TempDir()
+	members := []testArchiveMember{
+		{name: "a.json", content: "a"},
+		{name: "b.json", content: "b"},
+		{name: "c.json", content: "c"},
+	}
+	writeTestFiles(t, dir, map[string]string{"a.tar.gz": tarString(t, members, true)})
+
+	var tc tarCursors
+	defer tc.Close()
+	read := func(member string) (string, error) {
+		var content string
+		err := withArchiveMember(&tc, filepath.Join(dir, "a.tar.gz"), member, func(r io.Reader) error {
+			data, err := ioutil.ReadAll(r)
+			content = string(data)
+			return err
+		})
+		return content, err
+	}
+
+	// in order, then behind the cursor
+	for _, member := range []string{"b.json", "c.json", "a.json", "c.json"} {
+		got, err := read(member)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := strings.TrimSuffix(member, ".json"); got != want {
+			t.Errorf("got %q for %v, want %q", got, member, want)
+		}
+	}
+
+	if _, err := read("missing.json"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("got error %v, want missing.json not found", err)
+	}
+}
+
+func TestRunArchives(t *testing.T) {
+	members := append([]testArchiveMember{}, testArchiveMembers...)
+	members = append(members,
+		testArchiveMember{name: "inner/more.json.gz", content: gzipString(t, `[{"id": 4}, {"id": "x"}]`)},
+		testArchiveMember{name: "sheets/book.xlsx", content: xlsxString(t, map[string][][]interface{}{
+			"Items": {{"id", "name"}, {1, "a"}, {2, "b"}},
+		})},
+		testArchiveMember{name: "table.parquet", content: parquetString(t, testParquetSchema, `{"id": 1, "name": "a"}`, `{"name": "b"}`)},
+	)
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"data/a.zip":    zipString(t, members),
+		"data/b.tar.gz": tarString(t, members, true),
+	})
+
+	opts := newTestOptions(t, dir, filepath.Join(dir, "data"))
+	opts.Parallel = 2
+	result, err := NewValidator(opts).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]uint64{
+		"products.ndjson":        2,
+		"inner/items.csv":        3,
+		"inner/more.json":        2,
+		"sheets/book.xlsx":       2,
+		"table.parquet":          2,
+		"inner/readme.txt":       0,
+		"../escape.json":         0,
+		"escape.json":            0,
+		"inner/more.json.gz":     0,
+		"sheets/book.xlsx.bogus": 0,
+	}
+	for _, archive := range []string{"a.zip", "b.tar.gz"} {
+		for member, count := range want {
+			fr := result.Files[archive+"/"+member]
+			if count == 0 {
+				if fr != nil {
+					t.Errorf("got %v/%v validated", archive, member)
+				}
+				continue
+			}
+			if fr == nil {
+				t.Errorf("got no result for %v/%v in %v", archive, member, result.Files)
+				continue
+			}
+			if fr.RecordCount != count {
+				t.Errorf("got %v records for %v/%v, want %v", fr.RecordCount, archive, member, count)
+			}
+		}
+	}
+	if len(result.Files) != 10 {
+		t.Errorf("got %v files validated, want 10", len(result.Files))
+	}
+
+	// the parquet row without id fails the schema
+	if es := result.Files["a.zip/table.parquet"].ErrorStats; len(es) == 0 {
+		t.Error("got no errors for the parquet row without id")
+	}
+}
@@ -0,0 +1,281 @@
+package qa
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/DataHenHQ/datahen/records"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// ErrorTypeCoercionFailed is the error type of CSV values that cannot be
+// converted to the type declared by the schema
+const ErrorTypeCoercionFailed = "coercion_failed"
+
+// DefaultCoerceArraySeparator separates the items of CSV values coerced to arrays
+const DefaultCoerceArraySeparator = ","
+
+// fieldTypes are the JSON schema types declared for a field
+type fieldTypes struct {
+	types     []string
+	itemTypes []string
+}
+
+// schemaCoercer converts the string values of CSV records to the types
+// declared by the top level properties of the schema of their collection,
+// including the properties of its allOf and local $ref
+type schemaCoercer struct {
+	collections map[string]map[string]fieldTypes
+	separator   string
+}
+
+// newSchemaCoercer reads the declared property types of the schemas
+func newSchemaCoercer(loaders map[string]*gojsonschema.JSONLoader, separator string) (c *schemaCoercer, err error) {
+	c = &schemaCoercer{collections: map[string]map[string]fieldTypes{}, separator: separator}
+	for col, l := range loaders {
+		doc, err := (*l).LoadJSON()
+		if err != nil {
+			return nil, fmt.Errorf("cannot read the schema of collection %v: %v", col, err)
+		}
+		c.collections[col] = schemaFieldTypes(doc)
+	}
+	return c, nil
+}
+
+// schemaFieldTypes reads the types declared by the top level properties of
+// the schema, following the allOf of merged schemas and the local $ref of
+// bundled schemas. The first declaration of a property wins.
+func schemaFieldTypes(doc interface{}) (fields map[string]fieldTypes) {
+	fields = map[string]fieldTypes{}
+	collectFieldTypes(doc, doc, fields, 0)
+	return fields
+}
+
+// maxSchemaDepth bounds the $ref and allOf followed, against cyclic schemas
+const maxSchemaDepth = 32
+
+func collectFieldTypes(root interface{}, node interface{}, fields map[string]fieldTypes, depth int) {
+	schema, ok := resolveLocalRef(root, node, depth)
+	if !ok {
+		return
+	}
+
+	props, _ := schema["properties"].(map[string]interface{})
+	for name, p := range props {
+		if _, ok := fields[name]; ok {
+			continue
+		}
+		ft := fieldTypes{types: schemaTypes(root, p, depth)}
+		if prop, ok := resolveLocalRef(root, p, depth); ok {
+			ft.itemTypes = schemaTypes(root, prop["items"], depth)
+		}
+		if len(ft.types) > 0 {
+			fields[name] = ft
+		}
+	}
+
+	allOf, _ := schema["allOf"].([]interface{})
+	for _, sub := range allOf {
+		collectFieldTypes(root, sub, fields, depth+1)
+	}
+}
+
+// schemaTypes reads the types declared by the schema, or else by its allOf
+func schemaTypes(root interface{}, node interface{}, depth int) (types []string) {
+	schema, ok := resolveLocalRef(root, node, depth)
+	if !ok {
+		return nil
+	}
+	if types = declaredTypes(schema["type"]); len(types) > 0 {
+		return types
+	}
+
+	allOf, _ := schema["allOf"].([]interface{})
+	for _, sub := range allOf {
+		if types = schemaTypes(root, sub, depth+1); len(types) > 0 {
+			return types
+		}
+	}
+	return nil
+}
+
+// resolveLocalRef follows the local $ref of the schema node within the root
+// schema, returning the node itself when it isn't a reference
+func resolveLocalRef(root interface{}, node interface{}, depth int) (schema map[string]interface{}, ok bool) {
+	for ; depth <= maxSchemaDepth; depth++ {
+		schema, ok = node.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		ref, isRef := schema["$ref"].(string)
+		if !isRef || !strings.HasPrefix(ref, "#") {
+			return schema, true
+		}
+		if node, ok = resolvePointer(root, ref[1:]); !ok {
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// resolvePointer finds the node of the JSON pointer, as found in the URI
+// fragment of a $ref
+func resolvePointer(root interface{}, pointer string) (node interface{}, ok bool) {
+	if p, err := url.PathUnescape(pointer); err == nil {
+		pointer = p
+	}
+	if pointer == "" {
+		return root, true
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, false
+	}
+
+	node = root
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+		switch val := node.(type) {
+		case map[string]interface{}:
+			if node, ok = val[token]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(val) {
+				return nil, false
+			}
+			node = val[i]
+		default:
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+// declaredTypes reads a "type" keyword holding a type or a list of types
+func declaredTypes(t interface{}) (types []string) {
+	switch val := t.(type) {
+	case string:
+		return []string{val}
+	case []interface{}:
+		for _, item := range val {
+			if s, ok := item.(string); ok {
+				types = append(types, s)
+			}
+		}
+	}
+	return types
+}
+
+// coerce converts the values of the record data in place, returning the
+// errors of the values that cannot be converted. Empty values become null
+// when allowed, and are left out otherwise so they count as missing.
+func (c *schemaCoercer) coerce(data map[string]interface{}, collection string) (errs []records.SchemaError) {
+	fields, ok := c.collections[collection]
+	if !ok {
+		fields = c.collections[defaultCollection]
+	}
+
+	for name, ft := range fields {
+		s, ok := data[name].(string)
+		if !ok || hasType(ft.types, "string") {
+			continue
+		}
+
+		if strings.TrimSpace(s) == "" {
+			if hasType(ft.types, "null") {
+				data[name] = nil
+			} else {
+				delete(data, name)
+			}
+			continue
+		}
+
+		value, ok := c.convert(s, ft.types, ft.itemTypes)
+		if !ok {
+			errs = append(errs, records.SchemaError{
+				Field:       name,
+				ErrorType:   ErrorTypeCoercionFailed,
+				Description: fmt.Sprintf("Cannot convert the value to %v", strings.Join(ft.types, " or ")),
+				Value:       s,
+			})
+			continue
+		}
+		data[name] = value
+	}
+	return errs
+}
+
+// convert tries the declared types in order
+func (c *schemaCoercer) convert(s string, types []string, itemTypes []string) (value interface{}, ok bool) {
+	s = strings.TrimSpace(s)
+	for _, t := range types {
+		switch t {
+		case "string":
+			return s, true
+		case "integer":
+			if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+				return i, true
+			}
+		case "number":
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				return f, true
+			}
+		case "boolean":
+			if b, err := strconv.ParseBool(s); err == nil {
+				return b, true
+			}
+		case "null":
+			if s == "null" {
+				return nil, true
+			}
+		case "object":
+			obj := map[string]interface{}{}
+			if err := json.Unmarshal([]byte(s), &obj); err == nil {
+				return obj, true
+			}
+		case "array":
+			if arr, ok := c.convertArray(s, itemTypes); ok {
+				return arr, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// convertArray splits the value by the separator, converting the items to
+// their declared types
+func (c *schemaCoercer) convertArray(s string, itemTypes []string) (arr []interface{}, ok bool) {
+	if strings.HasPrefix(s, "[") {
+		if err := json.Unmarshal([]byte(s), &arr); err == nil {
+			return arr, true
+		}
+	}
+
+	parts := strings.Split(s, c.separator)
+	arr = make([]interface{}, 0, len(parts))
+	for _, part := range parts {
+		if len(itemTypes) == 0 {
+			arr = append(arr, strings.TrimSpace(part))
+			continue
+		}
+		item, ok := c.convert(part, itemTypes, nil)
+		if !ok {
+			return nil, false
+		}
+		arr = append(arr, item)
+	}
+	return arr, true
+}
+
+func hasType(types []string, t string) bool {
+	for _, tt := range types {
+		if tt == t {
+			return true
+		}
+	}
+	return false
+}
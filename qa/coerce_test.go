@@ -0,0 +1,134 @@
+package qa
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+func TestSchemaFieldTypes(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		want   map[string]fieldTypes
+	}{
+		{
+			name:   "properties",
+			schema: `{"properties": {"age": {"type": "integer"}, "tags": {"type": "array", "items": {"type": "number"}}, "any": {}}}`,
+			want: map[string]fieldTypes{
+				"age":  {types: []string{"integer"}},
+				"tags": {types: []string{"array"}, itemTypes: []string{"number"}},
+			},
+		},
+		{
+			name: "local refs",
+			schema: `{
+				"properties": {"price": {"$ref": "#/definitions/price"}, "sizes": {"type": "array", "items": {"$ref": "#/definitions/types~1size.json"}}},
+				"definitions": {"price": {"$ref": "#/definitions/amount"}, "amount": {"type": ["number", "null"]}, "types/size.json": {"type": "integer"}}
+			}`,
+			want: map[string]fieldTypes{
+				"price": {types: []string{"number", "null"}},
+				"sizes": {types: []string{"array"}, itemTypes: []string{"integer"}},
+			},
+		},
+		{
+			name: "allOf",
+			schema: `{"allOf": [
+				{"properties": {"age": {"type": "integer"}}},
+				{"$ref": "#/definitions/flags", "definitions": {"flags": {"properties": {"age": {"type": "string"}, "active": {"allOf": [{"type": "boolean"}]}}}}}
+			], "definitions": {"flags": {"properties": {"active": {"allOf": [{"type": "boolean"}]}}}}}`,
+			want: map[string]fieldTypes{
+				"age":    {types: []string{"integer"}},
+				"active": {types: []string{"boolean"}},
+			},
+		},
+		{
+			name:   "cyclic refs",
+			schema: `{"$ref": "#/definitions/a", "definitions": {"a": {"$ref": "#/definitions/b"}, "b": {"$ref": "#/definitions/a"}}}`,
+			want:   map[string]fieldTypes{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := decodeJSON([]byte(tt.schema))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := schemaFieldTypes(doc); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got field types %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCoerceAllOfMergedSchema(t *testing.T) {
+	schemas := [][]byte{
+		[]byte(`{"$schema": "http://json-schema.org/draft-07/schema#", "type": "object", "properties": {"name": {"type": "string"}, "price": {"type": "number"}}, "required": ["name"]}`),
+		[]byte(`{"type": "object", "properties": {"stock": {"$ref": "#/definitions/count"}, "active": {"type": "boolean"}}, "definitions": {"count": {"type": "integer", "minimum": 0}}}`),
+	}
+	merged, err := mergeSchemas(MergeStrategyAllOf, []string{"product.json", "stock.json"}, schemas)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loader, err := loadSchema(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := newSchemaCoercer(map[string]*gojsonschema.JSONLoader{defaultCollection: loader}, DefaultCoerceArraySeparator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := map[string]interface{}{"name": "Chair", "price": "19.99", "stock": "3", "active": "true"}
+	if errs := c.coerce(data, defaultCollection); len(errs) > 0 {
+		t.Fatalf("got coercion errors %v", errs)
+	}
+	want := map[string]interface{}{"name": "Chair", "price": 19.99, "stock": int64(3), "active": true}
+	if !reflect.DeepEqual(data, want) {
+		t.Errorf("got coerced data %v, want %v", data, want)
+	}
+
+	result, err := gojsonschema.Validate(*loader, gojsonschema.NewGoLoader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Valid() {
+		t.Errorf("got coerced data invalid: %v", result.Errors())
+	}
+
+	data = map[string]interface{}{"name": "Chair", "stock": "many"}
+	errs := c.coerce(data, defaultCollection)
+	if len(errs) != 1 || errs[0].Field != "stock" || errs[0].ErrorType != ErrorTypeCoercionFailed {
+		t.Errorf("got coercion errors %v, want a coercion_failed error of stock", errs)
+	}
+}
+
+func TestRunCoerceFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"data/a.csv": "id\n1\n2\n",
+		"data/b.csv": "id\n3\nx\n",
+	})
+
+	opts := newTestOptions(t, dir, filepath.Join(dir, "data"))
+	opts.Coerce = true
+	opts.Parallel = 2
+	result, err := NewValidator(opts).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the schema types read once convert the values of every file
+	if es := result.Files["a.csv"].ErrorStats; len(es) > 0 {
+		t.Errorf("got errors %v for the integer ids", es)
+	}
+	es := result.Files["b.csv"].ErrorStats["id."+ErrorTypeCoercionFailed]
+	if es == nil || es.ErrorCount != 1 {
+		t.Errorf("got errors %v, want the id that is not an integer failing coercion", result.Files["b.csv"].ErrorStats)
+	}
+}
@@ -0,0 +1,309 @@
+package qa
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/DataHenHQ/datahen/records"
+	"github.com/DataHenHQ/henqa_shared/customtypes"
+)
+
+// Change types reported by Compare, as the error types of the summary and
+// details reports
+const (
+	// ChangeTypeAdded is a record of the new file only.
+	ChangeTypeAdded = "added"
+	// ChangeTypeRemoved is a record of the old file only.
+	ChangeTypeRemoved = "removed"
+	// ChangeTypeChanged is a field whose value differs between the files.
+	ChangeTypeChanged = "changed"
+	// ChangeTypeMissingKey is a record without a value for the key fields.
+	ChangeTypeMissingKey = "missing_key"
+	// ChangeTypeDuplicateKey is a record whose key was already seen in its file.
+	ChangeTypeDuplicateKey = ErrorTypeDuplicateKey
+)
+
+// ErrNoCompareKeys is returned when comparing files without key fields
+var ErrNoCompareKeys = errors.New("no key fields to join the records on")
+
+// CompareOptions configures how Compare joins and compares the records.
+type CompareOptions struct {
+	// Keys are the fields joining the records of both files. Records of
+	// different collections never match.
+	Keys []string
+	// IgnoreFields are left out of the comparison, such as crawl timestamps.
+	IgnoreFields []string
+}
+
+// CompareResult keeps the outcome of comparing two files.
+type CompareResult struct {
+	Old            string `json:"old"`
+	New            string `json:"new"`
+	OldRecordCount uint64 `json:"old_record_count"`
+	NewRecordCount uint64 `json:"new_record_count"`
+	Added          uint64 `json:"added"`
+	Removed        uint64 `json:"removed"`
+	Changed        uint64 `json:"changed"`
+	Unchanged      uint64 `json:"unchanged"`
+	// FieldChanges counts the changed records by field.
+	FieldChanges map[string]uint64 `json:"field_changes"`
+	// ErrorStats are the change stats saved into the summary, by field.change_type.
+	ErrorStats customtypes.ErrorStats `json:"error_stats"`
+}
+
+// comparedRecord is a record of a compared file, with the JSON encoding of
+// each field so the old file is kept compact in memory
+type comparedRecord struct {
+	collection string
+	fields     map[string]json.RawMessage
+}
+
+// Compare joins the records of the old and new files on the key fields, and
+// writes the added, removed and changed records into the reports folder with
+// the summary and details layout of a validation, each change being an
+// error of the new file. The old file is held in memory.
+func (v *Validator) Compare(ctx context.Context, oldFile string, newFile string, co CompareOptions) (result *CompareResult, err error) {
+	if len(co.Keys) == 0 {
+		return nil, ErrNoCompareKeys
+	}
+	defer v.tars.Close()
+	outDir := v.opts.OutDir
+	if err := createOutDirIfNotExist(outDir); err != nil {
+		v.logln("gotten error creating output directory:", err.Error())
+		return nil, err
+	}
+
+	reportKey := reportKeys([]string{newFile}, []string{newFile})[newFile]
+	if newFile == StdinInput {
+		reportKey = v.opts.StdinName
+	}
+	keyField := strings.Join(co.Keys, ",")
+
+	result = &CompareResult{Old: oldFile, New: newFile, FieldChanges: map[string]uint64{}}
+	stats := customtypes.ErrorStats{}
+
+	// index the old records by key
+	oldRecs := map[string]*comparedRecord{}
+	includeCollection, err := v.readComparedRecords(ctx, oldFile, co, func(key string, rec *comparedRecord) error {
+		result.OldRecordCount++
+		switch {
+		case key == "":
+			addErrStat(stats, missingKeyError(keyField))
+		case oldRecs[key] != nil:
+			addErrStat(stats, duplicateKeyError(keyField, key))
+		default:
+			oldRecs[key] = rec
+		}
+		return nil
+	})
+	if err != nil {
+		v.logln("gotten error processing input file ", oldFile, ":", err.Error())
+		return nil, err
+	}
+
+	dw, err := newDetailWriter(outDir, reportKey, v.opts.DetailsFormat)
+	if err != nil {
+		v.logln("gotten error initializing output files for ", newFile, ":", err.Error())
+		return nil, err
+	}
+	defer dw.Close()
+
+	writeChanges := func(errs []records.SchemaError, rec *comparedRecord) error {
+		for _, e := range errs {
+			addErrStat(stats, e)
+		}
+		dw.recsWithErrors++
+		if v.maxRecsWithErrors != NoLimit && dw.recsWithErrors > v.maxRecsWithErrors {
+			return nil
+		}
+		return dw.Write(RecordWrapper{Errors: errs, Record: rec.record(includeCollection)})
+	}
+
+	// join the new records with the old ones
+	seen := map[string]bool{}
+	newIncludeCollection, err := v.readComparedRecords(ctx, newFile, co, func(key string, rec *comparedRecord) error {
+		result.NewRecordCount++
+		if key == "" {
+			return writeChanges([]records.SchemaError{missingKeyError(keyField)}, rec)
+		}
+		if seen[key] {
+			return writeChanges([]records.SchemaError{duplicateKeyError(keyField, key)}, rec)
+		}
+		seen[key] = true
+
+		old, ok := oldRecs[key]
+		if !ok {
+			result.Added++
+			return writeChanges([]records.SchemaError{{
+				Field:       keyField,
+				ErrorType:   ChangeTypeAdded,
+				Description: "The record is not in the old file",
+				Value:       recordKeyValue(key),
+			}}, rec)
+		}
+		delete(oldRecs, key)
+
+		errs := compareFields(old, rec)
+		if len(errs) == 0 {
+			result.Unchanged++
+			return nil
+		}
+		result.Changed++
+		for _, e := range errs {
+			result.FieldChanges[e.Field]++
+		}
+		return writeChanges(errs, rec)
+	})
+	if err != nil {
+		v.logln("gotten error processing input file ", newFile, ":", err.Error())
+		return nil, err
+	}
+	includeCollection = includeCollection || newIncludeCollection
+
+	// the old records left were removed
+	removedKeys := make([]string, 0, len(oldRecs))
+	for key := range oldRecs {
+		removedKeys = append(removedKeys, key)
+	}
+	sort.Strings(removedKeys)
+	for _, key := range removedKeys {
+		result.Removed++
+		err = writeChanges([]records.SchemaError{{
+			Field:       keyField,
+			ErrorType:   ChangeTypeRemoved,
+			Description: "The record is not in the new file",
+			Value:       recordKeyValue(key),
+		}}, oldRecs[key])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := dw.Close(); err != nil {
+		v.logln("gotten error initializing output files for ", newFile, ":", err.Error())
+		return nil, err
+	}
+
+	// percentages are taken against the records of both files
+	recordCount := result.NewRecordCount + result.Removed
+	for _, es := range stats {
+		es.RecordCount = recordCount
+		es.CalculatePercentage()
+	}
+	result.ErrorStats = stats
+	writeSummaryOutputs(outDir, reportKey, stats)
+	if err := writeOverallSummaryFile(outDir, v.opts.SummaryFile, map[string]customtypes.ErrorStats{reportKey: stats}); err != nil {
+		return nil, err
+	}
+
+	v.logln("")
+	v.logln("Done comparing records. The report folder would be located at", outDir)
+	return result, nil
+}
+
+// readComparedRecords streams the records of the file with the processor
+// of its extension, calling fn with their join key, empty when missing
+func (v *Validator) readComparedRecords(ctx context.Context, f string, co CompareOptions, fn func(key string, rec *comparedRecord) error) (includeCollection bool, err error) {
+	processFile, includeCollection, err := v.analyzeFileExtension(f, reportKeys([]string{f}, []string{f})[f], nil)
+	if err != nil {
+		return false, err
+	}
+
+	ignored := map[string]bool{"_collection": true}
+	for _, field := range co.IgnoreFields {
+		ignored[field] = true
+	}
+
+	err = processFile(f, v.opts.BatchSize, nil, func(recs []records.RecordGetSetterWithError) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		for _, rec := range recs {
+			cr := &comparedRecord{fields: map[string]json.RawMessage{}}
+			if includeCollection {
+				cr.collection = rec.GetCollection()
+			}
+			for _, k := range rec.Keys() {
+				if ignored[k] {
+					continue
+				}
+				value, _ := rec.Get(k)
+				raw, err := json.Marshal(value)
+				if err != nil {
+					return fmt.Errorf("cannot compare field %v: %v", k, err)
+				}
+				cr.fields[k] = raw
+			}
+			if err := fn(recordKey(rec, cr.collection, co.Keys), cr); err != nil {
+				return err
+			}
+		}
+		v.logf(".")
+		return nil
+	}, nil)
+	v.logln("")
+	return includeCollection, err
+}
+
+// compareFields returns a changed error for each field whose value differs,
+// with the old and new values
+func compareFields(old *comparedRecord, rec *comparedRecord) (errs []records.SchemaError) {
+	fields := make([]string, 0, len(rec.fields))
+	for k := range rec.fields {
+		fields = append(fields, k)
+	}
+	for k := range old.fields {
+		if _, ok := rec.fields[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+
+	for _, k := range fields {
+		oldValue, newValue := old.fields[k], rec.fields[k]
+		if bytes.Equal(oldValue, newValue) {
+			continue
+		}
+		errs = append(errs, records.SchemaError{
+			Field:       k,
+			ErrorType:   ChangeTypeChanged,
+			Description: "The field value changed",
+			Value:       map[string]interface{}{"old": oldValue, "new": newValue},
+		})
+	}
+	return errs
+}
+
+// record returns the fields of the record for the details file
+func (cr *comparedRecord) record(withCollection bool) map[string]interface{} {
+	o := make(map[string]interface{}, len(cr.fields)+1)
+	for k, raw := range cr.fields {
+		o[k] = raw
+	}
+	if withCollection && cr.collection != "" {
+		o["_collection"] = cr.collection
+	}
+	return o
+}
+
+func missingKeyError(keyField string) records.SchemaError {
+	return records.SchemaError{
+		Field:       keyField,
+		ErrorType:   ChangeTypeMissingKey,
+		Description: "The record has no value for the key fields",
+	}
+}
+
+func duplicateKeyError(keyField string, key string) records.SchemaError {
+	return records.SchemaError{
+		Field:       keyField,
+		ErrorType:   ChangeTypeDuplicateKey,
+		Description: "Another record of the file has the same key",
+		Value:       recordKeyValue(key),
+	}
+}
@@ -0,0 +1,161 @@
+package qa
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testCompareOptions writes the reports of the comparison into a temp dir
+func testCompareOptions(t *testing.T) Options {
+	t.Helper()
+	return Options{OutDir: filepath.Join(t.TempDir(), "reports"), Logger: ioutil.Discard}
+}
+
+func TestCompare(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"old.ndjson": `{"id": 1, "name": "a", "crawled_at": "monday"}
+{"id": 2, "name": "b"}
+{"id": 2, "name": "first of id 2 wins"}
+{"name": "no key"}
+{"id": 3, "name": "c"}
+{"id": 5, "name": "e", "price": 1}
+`,
+		"new.ndjson": `{"id": 1, "name": "a", "crawled_at": "tuesday"}
+{"id": 2, "name": "B"}
+{"id": 4, "name": "d"}
+{"id": 4, "name": "d again"}
+{"id": "", "name": "empty key"}
+{"id": 5, "name": "e", "stock": 2}
+`,
+	})
+
+	opts := testCompareOptions(t)
+	result, err := NewValidator(opts).Compare(context.Background(), filepath.Join(dir, "old.ndjson"), filepath.Join(dir, "new.ndjson"), CompareOptions{
+		Keys:         []string{"id"},
+		IgnoreFields: []string{"crawled_at"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := [...]uint64{result.OldRecordCount, result.NewRecordCount, result.Added, result.Removed, result.Changed, result.Unchanged}
+	if want := [...]uint64{6, 6, 1, 1, 2, 1}; got != want {
+		t.Errorf("got old, new, added, removed, changed and unchanged counts %v, want %v", got, want)
+	}
+	wantFields := map[string]uint64{"name": 1, "price": 1, "stock": 1}
+	if !reflect.DeepEqual(result.FieldChanges, wantFields) {
+		t.Errorf("got field changes %v, want %v", result.FieldChanges, wantFields)
+	}
+
+	// the missing and duplicate keys of both files are counted
+	wantStats := map[string]uint64{
+		"id.added":         1,
+		"id.removed":       1,
+		"id.missing_key":   2,
+		"id.duplicate_key": 2,
+		"name.changed":     1,
+		"price.changed":    1,
+		"stock.changed":    1,
+	}
+	gotStats := map[string]uint64{}
+	for key, es := range result.ErrorStats {
+		gotStats[key] = es.ErrorCount
+		if es.RecordCount != 7 {
+			t.Errorf("got %v records for %v, want the 7 records of both files", es.RecordCount, key)
+		}
+	}
+	if !reflect.DeepEqual(gotStats, wantStats) {
+		t.Errorf("got change stats %v, want %v", gotStats, wantStats)
+	}
+
+	// the details hold the changes of the new file in order, then the removed records
+	details := readTestDetails(t, opts.OutDir, "new.ndjson")
+	var types [][]string
+	for _, rw := range details {
+		var recTypes []string
+		for _, e := range rw.Errors {
+			recTypes = append(recTypes, e.Field+"."+e.ErrorType)
+		}
+		types = append(types, recTypes)
+	}
+	wantTypes := [][]string{
+		{"name.changed"},
+		{"id.added"},
+		{"id.duplicate_key"},
+		{"id.missing_key"},
+		{"price.changed", "stock.changed"},
+		{"id.removed"},
+	}
+	if !reflect.DeepEqual(types, wantTypes) {
+		t.Errorf("got details %v, want %v", types, wantTypes)
+	}
+	if removed := details[len(details)-1].Record.(map[string]interface{}); removed["name"] != "c" {
+		t.Errorf("got removed record %v, want the old record of id 3", removed)
+	}
+}
+
+func TestCompareCompositeKeys(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"old.json": `[
+  {"_collection": "products", "id": 1, "sku": "a", "price": 1},
+  {"_collection": "products", "id": 1, "sku": "b", "price": 2},
+  {"_collection": "reviews", "id": 1, "sku": "a", "rating": 5},
+  {"_collection": "products", "id": 2, "price": 3}
+]`,
+		"new.json": `[
+  {"_collection": "products", "id": 1, "sku": "b", "price": 2},
+  {"_collection": "products", "id": 1, "sku": "a", "price": 1.5},
+  {"_collection": "offers", "id": 1, "sku": "a", "rating": 5},
+  {"_collection": "products", "id": 2, "price": 3}
+]`,
+	})
+
+	opts := testCompareOptions(t)
+	result, err := NewValidator(opts).Compare(context.Background(), filepath.Join(dir, "old.json"), filepath.Join(dir, "new.json"), CompareOptions{
+		Keys: []string{"id", "sku"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the records of other collections never match, the records without sku have no key
+	got := [...]uint64{result.Added, result.Removed, result.Changed, result.Unchanged}
+	if want := [...]uint64{1, 1, 1, 1}; got != want {
+		t.Errorf("got added, removed, changed and unchanged counts %v, want %v", got, want)
+	}
+	if es := result.ErrorStats["id,sku.missing_key"]; es == nil || es.ErrorCount != 2 {
+		t.Errorf("got missing key stat %+v, want the 2 records without sku", es)
+	}
+}
+
+func TestCompareLimits(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"old.ndjson": "{\"id\": 1}\n{\"id\": 2}\n{\"id\": 3}\n",
+		"new.ndjson": "{\"id\": 4}\n{\"id\": 5}\n",
+	})
+	old, new := filepath.Join(dir, "old.ndjson"), filepath.Join(dir, "new.ndjson")
+
+	if _, err := NewValidator(testCompareOptions(t)).Compare(context.Background(), old, new, CompareOptions{}); err != ErrNoCompareKeys {
+		t.Errorf("got error %v, want no compare keys", err)
+	}
+
+	opts := testCompareOptions(t)
+	limit := 2
+	opts.MaxRecsWithErrors = &limit
+	result, err := NewValidator(opts).Compare(context.Background(), old, new, CompareOptions{Keys: []string{"id"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Added != 2 || result.Removed != 3 {
+		t.Errorf("got %v added and %v removed, want 2 and 3", result.Added, result.Removed)
+	}
+	if details := readTestDetails(t, opts.OutDir, "new.ndjson"); len(details) != limit {
+		t.Errorf("got %v details, want %v", len(details), limit)
+	}
+}
@@ -0,0 +1,150 @@
+package qa
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/DataHenHQ/datahen/records"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression codecs of input files
+const (
+	compressionNone  = ""
+	compressionGzip  = "gzip"
+	compressionZstd  = "zstd"
+	compressionBzip2 = "bzip2"
+)
+
+var compressionExts = map[string]string{
+	".gz":   compressionGzip,
+	".gzip": compressionGzip,
+	".zst":  compressionZstd,
+	".zstd": compressionZstd,
+	".bz2":  compressionBzip2,
+}
+
+var compressionMagics = []struct {
+	codec string
+	magic []byte
+}{
+	{compressionGzip, []byte{0x1f, 0x8b}},
+	{compressionZstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+	{compressionBzip2, []byte("BZh")},
+}
+
+// logicalName strips the compression extension from the file name, so
+// products.json.gz is named products.json
+func logicalName(f string) string {
+	ext := strings.ToLower(filepath.Ext(f))
+	if _, ok := compressionExts[ext]; ok {
+		return strings.TrimSuffix(f, filepath.Ext(f))
+	}
+	return f
+}
+
+// detectCompression detects the compression codec of the file by its
+// extension and its magic bytes, returning the logical name of the file
+func detectCompression(f string) (codec string, name string, err error) {
+	extCodec := compressionExts[strings.ToLower(filepath.Ext(f))]
+
+	magicCodec, err := sniffCompression(f)
+	if err != nil {
+		return compressionNone, f, err
+	}
+
+	if extCodec != compressionNone && magicCodec != extCodec {
+		return compressionNone, f, fmt.Errorf("%s is not a valid %s file", f, extCodec)
+	}
+	if magicCodec == compressionNone {
+		return compressionNone, f, nil
+	}
+	return magicCodec, logicalName(f), nil
+}
+
+func sniffCompression(f string) (codec string, err error) {
+	file, err := os.Open(f)
+	if err != nil {
+		return compressionNone, err
+	}
+	defer file.Close()
+
+	return sniffReader(bufio.NewReader(file))
+}
+
+// sniffReader detects the compression codec by the magic bytes at the head of
+// the stream, without consuming them
+func sniffReader(br *bufio.Reader) (codec string, err error) {
+	header, err := br.Peek(4)
+	if err != nil && err != io.EOF {
+		return compressionNone, err
+	}
+
+	for _, cm := range compressionMagics {
+		if !bytes.HasPrefix(header, cm.magic) {
+			continue
+		}
+		// bzip2 streams go on with their block size, from 1 to 9
+		if cm.codec == compressionBzip2 && (len(header) < 4 || header[3] < '1' || header[3] > '9') {
+			continue
+		}
+		return cm.codec, nil
+	}
+	return compressionNone, nil
+}
+
+// decompressReader wraps the stream with a decompressor when its magic bytes
+// show it is compressed
+func decompressReader(r io.Reader) (rc io.ReadCloser, codec string, err error) {
+	br := bufio.NewReader(r)
+	codec, err = sniffReader(br)
+	if err != nil {
+		return nil, compressionNone, err
+	}
+	rc, err = newDecompressor(br, codec)
+	return rc, codec, err
+}
+
+// newDecompressor wraps the reader with the decompressor of the codec
+func newDecompressor(r io.Reader, codec string) (rc io.ReadCloser, err error) {
+	switch codec {
+	case compressionGzip:
+		return gzip.NewReader(r)
+	case compressionZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	case compressionBzip2:
+		return ioutil.NopCloser(bzip2.NewReader(r)), nil
+	}
+	return ioutil.NopCloser(r), nil
+}
+
+// decompressingProcessor streams the decompressed file into the reader processor
+func decompressingProcessor(codec string, process processReaderFn) processFileStreamFn {
+	return func(filename string, batchSize int, configReaderFn records.ConfigReaderFn, validateFn records.ValidateFn, validatePreRecords records.ValidateHeadersFn) (err error) {
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		r, err := newDecompressor(f, codec)
+		if err != nil {
+			return fmt.Errorf("cannot decompress %v: %v", filename, err)
+		}
+		defer r.Close()
+
+		return process(r, batchSize, configReaderFn, validateFn, validatePreRecords)
+	}
+}
@@ -0,0 +1,145 @@
+package qa
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// testBzip2 is {"id": 1}\n{"id": 2}\n{"id": "x"}\n compressed with bzip2
+const testBzip2 = "\x42\x5a\x68\x39\x31\x41\x59\x26\x53\x59\x36\xac\x3b\x96\x00\x00\x0d\xd9\x80\x00\x10\x50\x00\x30\x10\x04\x20\x00\x4a\x20\x00\x21\xb5\x20\x68\xfd\x50\x80\x69\xa6\x84\xe1\x62\x6d\xa9\x62\x54\x9d\x1c\x3f\x17\x72\x45\x38\x50\x90\x36\xac\x3b\x96"
+
+// zstdString compresses the string with zstd
+func zstdString(t *testing.T, s string) string {
+	t.Helper()
+	zw, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zw.Close()
+	return string(zw.EncodeAll([]byte(s), nil))
+}
+
+func TestSniffReader(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"gzip", gzipString(t, "[]"), compressionGzip},
+		{"zstd", zstdString(t, "[]"), compressionZstd},
+		{"bzip2", testBzip2, compressionBzip2},
+		{"bzip2 without block size", "BZh0...", compressionNone},
+		{"short bzip2 header", "BZh", compressionNone},
+		{"plain", `{"id": 1}`, compressionNone},
+		{"empty", "", compressionNone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			br := bufio.NewReader(strings.NewReader(tt.input))
+			got, err := sniffReader(br)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got codec %q, want %q", got, tt.want)
+			}
+			// the magic bytes are left for the decompressor
+			if rest, err := ioutil.ReadAll(br); err != nil || string(rest) != tt.input {
+				t.Errorf("got the stream consumed by sniffing, error %v", err)
+			}
+		})
+	}
+}
+
+func TestDetectCompression(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"a.json.gz":   gzipString(t, "[]"),
+		"b.json":      gzipString(t, "[]"),
+		"c.json.gz":   "[]",
+		"d.csv.zst":   zstdString(t, "id\n1\n"),
+		"e.json.bz2":  gzipString(t, "[]"),
+		"f.ndjson":    "{}",
+		"g.ndjson.GZ": gzipString(t, "{}"),
+	})
+
+	tests := []struct {
+		name     string
+		codec    string
+		logical  string
+		hasError bool
+	}{
+		{"a.json.gz", compressionGzip, "a.json", false},
+		{"b.json", compressionGzip, "b.json", false},
+		{"c.json.gz", "", "", true},
+		{"d.csv.zst", compressionZstd, "d.csv", false},
+		{"e.json.bz2", "", "", true},
+		{"f.ndjson", compressionNone, "f.ndjson", false},
+		{"g.ndjson.GZ", compressionGzip, "g.ndjson", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codec, name, err := detectCompression(filepath.Join(dir, tt.name))
+			if tt.hasError {
+				if err == nil {
+					t.Errorf("got codec %q, want an error", codec)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if codec != tt.codec || name != filepath.Join(dir, tt.logical) {
+				t.Errorf("got codec %q and name %v, want %q and %v", codec, name, tt.codec, tt.logical)
+			}
+		})
+	}
+}
+
+func TestRunCompressedFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"data/products.ndjson.gz": gzipString(t, "{\"id\": 1}\n{\"id\": \"x\"}\n"),
+		"data/items.csv.zst":      zstdString(t, "id,name\n1,a\n2,b\n3,c\n"),
+		"data/more.json.bz2":      testBzip2,
+		"data/table.parquet.gz":   gzipString(t, parquetString(t, testParquetSchema, `{"id": 1, "name": "a"}`)),
+		"data/book.xlsx.zst": zstdString(t, xlsxString(t, map[string][][]interface{}{
+			"Items": {{"id"}, {1}, {2}},
+		})),
+	})
+
+	opts := newTestOptions(t, dir, filepath.Join(dir, "data"))
+	result, err := NewValidator(opts).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Skipped) > 0 {
+		t.Errorf("got skipped files %v", result.Skipped)
+	}
+
+	want := map[string]uint64{
+		"products.ndjson": 2,
+		"items.csv":       3,
+		"more.json":       3,
+		"table.parquet":   1,
+		"book.xlsx":       2,
+	}
+	for key, count := range want {
+		fr := result.Files[key]
+		if fr == nil {
+			t.Errorf("got no result for %v in %v", key, result.Files)
+			continue
+		}
+		if fr.RecordCount != count {
+			t.Errorf("got %v records for %v, want %v", fr.RecordCount, key, count)
+		}
+	}
+}
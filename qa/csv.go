@@ -0,0 +1,424 @@
+package qa
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/DataHenHQ/datahen/records"
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/ghodss/yaml"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/transform"
+)
+
+// CSVDialect describes how CSV files are written. Empty fields keep the
+// default: comma delimited, double quoted, without comments and UTF-8 encoded.
+type CSVDialect struct {
+	// Delimiter separates the fields, such as ";" or "\t".
+	Delimiter string `json:"delimiter,omitempty"`
+	// Quote quotes the fields, "none" reads the quotes as part of the values.
+	Quote string `json:"quote,omitempty"`
+	// Comment starts the lines that are skipped, such as "#".
+	Comment string `json:"comment,omitempty"`
+	// Encoding is the character encoding of the file, such as "latin1" or "utf-16le".
+	Encoding string `json:"encoding,omitempty"`
+	// KeepBOM keeps the byte order mark at the start of the file as part of the first header.
+	KeepBOM bool `json:"keep_bom,omitempty"`
+	// Header are the column names of files without a header row.
+	Header []string `json:"header,omitempty"`
+}
+
+// CSVDialectRule applies its dialect to the CSV files whose report path, such
+// as siteA/products.csv, matches the doublestar glob pattern.
+type CSVDialectRule struct {
+	Pattern string `json:"pattern"`
+	CSVDialect
+}
+
+// csvOptions is a CSVDialect ready to read files
+type csvOptions struct {
+	comma    rune
+	quote    rune
+	comment  rune
+	encoding encoding.Encoding
+	keepBOM  bool
+	header   []string
+	// coercer converts the values to their schema types, when set
+	coercer *schemaCoercer
+}
+
+// defaultSyntax tells whether the options split the fields the default way,
+// comma delimited and double quoted without comments
+func (co *csvOptions) defaultSyntax() bool {
+	return co.comma == ',' && co.quote == '"' && co.comment == 0
+}
+
+// isDefault tells whether the dialect reads CSV files the default way
+func (d CSVDialect) isDefault() bool {
+	return d.Delimiter == "" && d.Quote == "" && d.Comment == "" && d.Encoding == "" && !d.KeepBOM && len(d.Header) == 0
+}
+
+// merge overrides the dialect with the fields set in o
+func (d CSVDialect) merge(o CSVDialect) CSVDialect {
+	if o.Delimiter != "" {
+		d.Delimiter = o.Delimiter
+	}
+	if o.Quote != "" {
+		d.Quote = o.Quote
+	}
+	if o.Comment != "" {
+		d.Comment = o.Comment
+	}
+	if o.Encoding != "" {
+		d.Encoding = o.Encoding
+	}
+	if o.KeepBOM {
+		d.KeepBOM = true
+	}
+	if len(o.Header) > 0 {
+		d.Header = o.Header
+	}
+	return d
+}
+
+// Validate returns an error when the dialect cannot be used to read files
+func (d CSVDialect) Validate() (err error) {
+	_, err = d.options()
+	return err
+}
+
+func (d CSVDialect) options() (co *csvOptions, err error) {
+	co = &csvOptions{comma: ',', quote: '"', keepBOM: d.KeepBOM, header: d.Header}
+
+	if d.Delimiter != "" {
+		if co.comma, err = dialectRune("delimiter", d.Delimiter); err != nil {
+			return nil, err
+		}
+	}
+	switch d.Quote {
+	case "":
+	case "none":
+		co.quote = 0
+	default:
+		if co.quote, err = dialectRune("quote", d.Quote); err != nil {
+			return nil, err
+		}
+	}
+	if d.Comment != "" {
+		if co.comment, err = dialectRune("comment", d.Comment); err != nil {
+			return nil, err
+		}
+	}
+	if co.comma == co.quote || co.comma == co.comment || (co.quote != 0 && co.quote == co.comment) {
+		return nil, fmt.Errorf("the CSV delimiter, quote and comment must be different")
+	}
+
+	switch strings.ToLower(d.Encoding) {
+	case "", "utf-8", "utf8":
+	default:
+		co.encoding, err = htmlindex.Get(d.Encoding)
+		if err != nil {
+			return nil, fmt.Errorf("unknown CSV encoding %q", d.Encoding)
+		}
+	}
+	return co, nil
+}
+
+// dialectRune parses a single character of the dialect, accepting \t and
+// "tab" for tabs
+func dialectRune(name string, s string) (r rune, err error) {
+	if s == `\t` || strings.EqualFold(s, "tab") {
+		return '\t', nil
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	if size != len(s) || r == utf8.RuneError || r == '\r' || r == '\n' {
+		return 0, fmt.Errorf("invalid CSV %s %q, expected a single character", name, s)
+	}
+	return r, nil
+}
+
+// ParseCSVDialects reads the per file CSV dialects from a JSON or YAML file
+// holding a list of rules such as [{"pattern": "**/*.tsv", "delimiter": "\t"}].
+// The first matching rule applies.
+func ParseCSVDialects(dialectsFile string) (rules []CSVDialectRule, err error) {
+	if dialectsFile == "" {
+		return nil, nil
+	}
+
+	data, err := readFile(dialectsFile)
+	if err != nil {
+		return nil, err
+	}
+	switch filepath.Ext(dialectsFile) {
+	case ".yaml", ".yml":
+		data, err = yaml.YAMLToJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("error converting YAML to JSON: %v", err)
+		}
+	}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid CSV dialects file %v: %v", dialectsFile, err)
+	}
+
+	for _, rule := range rules {
+		if rule.Pattern == "" || !doublestar.ValidatePattern(rule.Pattern) {
+			return nil, fmt.Errorf("invalid CSV dialect pattern %q in %v", rule.Pattern, dialectsFile)
+		}
+		if err := rule.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid CSV dialect %q in %v: %v", rule.Pattern, dialectsFile, err)
+		}
+	}
+	return rules, nil
+}
+
+// csvDialectFor returns the dialect of the file with the report key, from
+// the first matching per file rule over the default dialect
+func (v *Validator) csvDialectFor(reportKey string) CSVDialect {
+	d := v.opts.CSVDialect
+	for _, rule := range v.opts.CSVDialects {
+		if ok, _ := doublestar.Match(rule.Pattern, reportKey); ok {
+			return d.merge(rule.CSVDialect)
+		}
+	}
+	return d
+}
+
+// csvOptionsFor returns the options reading the CSV file with the report key,
+// tab delimited for .tsv files, and whether they are the default ones
+func (v *Validator) csvOptionsFor(reportKey string) (co *csvOptions, isDefault bool, err error) {
+	d := v.csvDialectFor(reportKey)
+	isDefault = d.isDefault()
+	if d.Delimiter == "" && strings.EqualFold(filepath.Ext(logicalName(reportKey)), ".tsv") {
+		d.Delimiter = `\t`
+	}
+	co, err = d.options()
+	return co, isDefault, err
+}
+
+// csvFileProcessor reads plain CSV files with the dialect, the same way as
+// the compressed, archived and stdin CSV streams. Plain .csv files of the
+// default dialect are read by records.ProcessCSVFile instead.
+func csvFileProcessor(co *csvOptions) processFileStreamFn {
+	process := csvReaderProcessor(co)
+	return func(filename string, batchSize int, configReaderFn records.ConfigReaderFn, validateFn records.ValidateFn, validatePreRecords records.ValidateHeadersFn) (err error) {
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		return process(f, batchSize, configReaderFn, validateFn, validatePreRecords)
+	}
+}
+
+// csvReaderProcessor reads the records of a CSV stream with the dialect,
+// using the first row as the headers unless the dialect has them. The rows
+// are read by the reader of configReaderFn, such as a workflow's, unless the
+// dialect sets its own delimiter, quote or comment.
+func csvReaderProcessor(co *csvOptions) processReaderFn {
+	return func(r io.Reader, batchSize int, configReaderFn records.ConfigReaderFn, validateFn records.ValidateFn, validatePreRecords records.ValidateHeadersFn) (err error) {
+		if co.encoding != nil {
+			r = transform.NewReader(r, co.encoding.NewDecoder())
+		}
+		br := bufio.NewReader(r)
+		if !co.keepBOM {
+			if err := skipBOM(br); err != nil && err != io.EOF {
+				return err
+			}
+		}
+
+		var cr csvRowReader = &csvReader{r: br, co: co}
+		if configReaderFn != nil && co.defaultSyntax() {
+			if wr := configReaderFn(br); wr != nil {
+				cr = wr
+			}
+		}
+
+		headers := co.header
+		if len(headers) == 0 {
+			headers, err = cr.Read()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		if validatePreRecords != nil {
+			stop, err := validatePreRecords(headers)
+			if err != nil {
+				return err
+			}
+			if stop {
+				return nil
+			}
+		}
+
+		batcher := newRecordBatcher(batchSize, validateFn)
+		for {
+			row, err := cr.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+
+			data := make(map[string]interface{}, len(headers))
+			for i, h := range headers {
+				if i < len(row) {
+					data[h] = row[i]
+				}
+			}
+			rec := newMapRecord(data)
+			if co.coercer != nil {
+				rec.addCoercionErrors(co.coercer.coerce(data, rec.collection))
+			}
+			if err := batcher.AddRecord(rec); err != nil {
+				return err
+			}
+		}
+
+		return batcher.Flush()
+	}
+}
+
+// csvRowReader reads the rows of a CSV stream, such as csvReader or the
+// reader configured by a workflow
+type csvRowReader interface {
+	Read() (row []string, err error)
+}
+
+// csvReader reads the rows of a CSV stream. Quoted fields may span lines and
+// escape the quote by doubling it, empty lines and comment lines are skipped.
+type csvReader struct {
+	r    *bufio.Reader
+	co   *csvOptions
+	line int
+}
+
+// skipBOM skips the UTF-8 byte order mark at the start of the stream, if any
+func skipBOM(br *bufio.Reader) (err error) {
+	r, _, err := br.ReadRune()
+	if err != nil {
+		return err
+	}
+	if r != '\uFEFF' {
+		return br.UnreadRune()
+	}
+	return nil
+}
+
+// Read returns the fields of the next row, or io.EOF at the end of the stream
+func (cr *csvReader) Read() (row []string, err error) {
+	for {
+		row, err = cr.readRow()
+		if err != nil || row != nil {
+			return row, err
+		}
+	}
+}
+
+// readRow returns nil without error on skipped lines
+func (cr *csvReader) readRow() (row []string, err error) {
+	cr.line++
+
+	r, _, err := cr.r.ReadRune()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case r == '\n':
+		return nil, nil
+	case r == '\r':
+		cr.skipLF()
+		return nil, nil
+	case cr.co.comment != 0 && r == cr.co.comment:
+		if _, err := cr.r.ReadString('\n'); err != nil && err != io.EOF {
+			return nil, err
+		}
+		return nil, nil
+	}
+	if err := cr.r.UnreadRune(); err != nil {
+		return nil, err
+	}
+
+	var field strings.Builder
+	fieldStart := true
+	for {
+		r, _, err := cr.r.ReadRune()
+		if err == io.EOF {
+			return append(row, field.String()), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case fieldStart && cr.co.quote != 0 && r == cr.co.quote:
+			if err := cr.readQuoted(&field); err != nil {
+				return nil, err
+			}
+		case r == cr.co.comma:
+			row = append(row, field.String())
+			field.Reset()
+			fieldStart = true
+			continue
+		case r == '\n':
+			return append(row, field.String()), nil
+		case r == '\r':
+			cr.skipLF()
+			return append(row, field.String()), nil
+		default:
+			field.WriteRune(r)
+		}
+		fieldStart = false
+	}
+}
+
+// readQuoted reads a quoted field up to its closing quote
+func (cr *csvReader) readQuoted(field *strings.Builder) (err error) {
+	startLine := cr.line
+	for {
+		r, _, err := cr.r.ReadRune()
+		if err == io.EOF {
+			return fmt.Errorf("unterminated quoted field starting at line %d", startLine)
+		}
+		if err != nil {
+			return err
+		}
+
+		if r == cr.co.quote {
+			next, _, err := cr.r.ReadRune()
+			if err == nil && next == cr.co.quote {
+				field.WriteRune(r)
+				continue
+			}
+			if err == nil {
+				err = cr.r.UnreadRune()
+			}
+			if err == io.EOF {
+				err = nil
+			}
+			return err
+		}
+		if r == '\n' {
+			cr.line++
+		}
+		field.WriteRune(r)
+	}
+}
+
+func (cr *csvReader) skipLF() {
+	if b, err := cr.r.Peek(1); err == nil && b[0] == '\n' {
+		cr.r.ReadByte()
+	}
+}
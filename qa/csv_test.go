@@ -0,0 +1,243 @@
+package qa
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DataHenHQ/datahen/records"
+)
+
+func TestCSVReaderRead(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect CSVDialect
+		in      string
+		want    [][]string
+	}{
+		{
+			name: "plain",
+			in:   "a,b,c\n1,2,3\n",
+			want: [][]string{{"a", "b", "c"}, {"1", "2", "3"}},
+		},
+		{
+			name: "quoted delimiter",
+			in:   "name,tags\n\"Doe, John\",\"a,b\"\n",
+			want: [][]string{{"name", "tags"}, {"Doe, John", "a,b"}},
+		},
+		{
+			name: "embedded newlines",
+			in:   "id,note\n1,\"first line\nsecond line\"\n2,\"crlf\r\nline\"\r\n",
+			want: [][]string{{"id", "note"}, {"1", "first line\nsecond line"}, {"2", "crlf\r\nline"}},
+		},
+		{
+			name: "escaped quotes",
+			in:   "id,quote\n1,\"she said \"\"hi\"\"\"\n2,\"\"\"\"\n",
+			want: [][]string{{"id", "quote"}, {"1", `she said "hi"`}, {"2", `"`}},
+		},
+		{
+			name: "quotes inside unquoted field",
+			in:   "id,size\n1,12\"\n",
+			want: [][]string{{"id", "size"}, {"1", `12"`}},
+		},
+		{
+			name: "empty fields",
+			in:   "a,b,c\n,,\n\"\",x,\n",
+			want: [][]string{{"a", "b", "c"}, {"", "", ""}, {"", "x", ""}},
+		},
+		{
+			name: "crlf and empty lines",
+			in:   "a,b\r\n\r\n1,2\r\n\n3,4",
+			want: [][]string{{"a", "b"}, {"1", "2"}, {"3", "4"}},
+		},
+		{
+			name: "ragged rows",
+			in:   "a,b,c\n1\n1,2,3,4\n",
+			want: [][]string{{"a", "b", "c"}, {"1"}, {"1", "2", "3", "4"}},
+		},
+		{
+			name:    "semicolon delimiter",
+			dialect: CSVDialect{Delimiter: ";"},
+			in:      "a;b\n\"1;5\";2,5\n",
+			want:    [][]string{{"a", "b"}, {"1;5", "2,5"}},
+		},
+		{
+			name:    "tab delimiter",
+			dialect: CSVDialect{Delimiter: `\t`},
+			in:      "a\tb\n1,5\t\"x\ty\"\n",
+			want:    [][]string{{"a", "b"}, {"1,5", "x\ty"}},
+		},
+		{
+			name:    "single quote",
+			dialect: CSVDialect{Quote: "'"},
+			in:      "a,b\n'x,y','it''s'\n\"q\",z\n",
+			want:    [][]string{{"a", "b"}, {"x,y", "it's"}, {`"q"`, "z"}},
+		},
+		{
+			name:    "no quote",
+			dialect: CSVDialect{Quote: "none"},
+			in:      "a,b\n\"x,y\"\n",
+			want:    [][]string{{"a", "b"}, {`"x`, `y"`}},
+		},
+		{
+			name:    "comments",
+			dialect: CSVDialect{Comment: "#"},
+			in:      "# exported today\na,b\n#1,2\n3,#4\n",
+			want:    [][]string{{"a", "b"}, {"3", "#4"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			co, err := tt.dialect.options()
+			if err != nil {
+				t.Fatal(err)
+			}
+			cr := &csvReader{r: bufio.NewReader(strings.NewReader(tt.in)), co: co}
+
+			var rows [][]string
+			for {
+				row, err := cr.Read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				rows = append(rows, row)
+			}
+			if !reflect.DeepEqual(rows, tt.want) {
+				t.Errorf("got rows %q, want %q", rows, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVReaderUnterminatedQuote(t *testing.T) {
+	co, _ := CSVDialect{}.options()
+	cr := &csvReader{r: bufio.NewReader(strings.NewReader("a,b\n1,\"open\n2,3\n")), co: co}
+
+	if _, err := cr.Read(); err != nil {
+		t.Fatal(err)
+	}
+	_, err := cr.Read()
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("got error %v, want an unterminated quoted field at line 2", err)
+	}
+}
+
+func TestCSVReaderProcessor(t *testing.T) {
+	tests := []struct {
+		name    string
+		dialect CSVDialect
+		in      string
+		want    []map[string]interface{}
+	}{
+		{
+			name: "ragged rows",
+			in:   "a,b,c\n1\n1,2,3,4\n",
+			want: []map[string]interface{}{
+				{"a": "1"},
+				{"a": "1", "b": "2", "c": "3"},
+			},
+		},
+		{
+			name: "bom stripped",
+			in:   "\uFEFFid,name\n1,x\n",
+			want: []map[string]interface{}{{"id": "1", "name": "x"}},
+		},
+		{
+			name:    "bom kept",
+			dialect: CSVDialect{KeepBOM: true},
+			in:      "\uFEFFid,name\n1,x\n",
+			want:    []map[string]interface{}{{"\uFEFFid": "1", "name": "x"}},
+		},
+		{
+			name:    "header",
+			dialect: CSVDialect{Header: []string{"id", "name"}},
+			in:      "1,x\n2,y\n",
+			want:    []map[string]interface{}{{"id": "1", "name": "x"}, {"id": "2", "name": "y"}},
+		},
+		{
+			name:    "latin1",
+			dialect: CSVDialect{Encoding: "latin1"},
+			in:      "name\nJos\xe9\n",
+			want:    []map[string]interface{}{{"name": "José"}},
+		},
+		{
+			name: "empty",
+			in:   "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			co, err := tt.dialect.options()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []map[string]interface{}
+			validateFn := func(recs []records.RecordGetSetterWithError) error {
+				for _, rec := range recs {
+					got = append(got, rec.(*mapRecord).data)
+				}
+				return nil
+			}
+			err = csvReaderProcessor(co)(strings.NewReader(tt.in), 1, nil, validateFn, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got records %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnalyzeCSVFileReader(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"a.csv": "id\n1\n", "b.tsv": "id\n1\n"})
+	loaders := testSchemaLoaders(t, testSchema)
+
+	tests := []struct {
+		name        string
+		file        string
+		opts        Options
+		coerce      bool
+		wantDatahen bool
+	}{
+		{name: "default dialect", file: "a.csv", wantDatahen: true},
+		{name: "dialect", file: "a.csv", opts: Options{CSVDialect: CSVDialect{Delimiter: ";"}}},
+		{name: "dialect rule", file: "a.csv", opts: Options{CSVDialects: []CSVDialectRule{{Pattern: "*.csv", CSVDialect: CSVDialect{Encoding: "latin1"}}}}},
+		{name: "coerce", file: "a.csv", coerce: true},
+		{name: "tsv", file: "b.tsv"},
+	}
+
+	datahen := reflect.ValueOf(records.ProcessCSVFile).Pointer()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.Logger = ioutil.Discard
+			v := NewValidator(tt.opts)
+			var coercer *schemaCoercer
+			if tt.coerce {
+				var err error
+				coercer, err = newSchemaCoercer(loaders, DefaultCoerceArraySeparator)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			processFile, _, err := v.analyzeFileExtension(filepath.Join(dir, tt.file), tt.file, coercer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := reflect.ValueOf(processFile).Pointer() == datahen; got != tt.wantDatahen {
+				t.Errorf("got the datahen reader %v, want %v", got, tt.wantDatahen)
+			}
+		})
+	}
+}
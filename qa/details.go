@@ -0,0 +1,105 @@
+package qa
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Details file formats
+const (
+	DetailsFormatJSON   = "json"
+	DetailsFormatNDJSON = "ndjson"
+)
+
+// detailWriter streams the records with errors of a single file into its
+// details file, either as a JSON array or as one record per line.
+type detailWriter struct {
+	f       *os.File
+	w       *bufio.Writer
+	enc     *json.Encoder
+	ndjson  bool
+	written int
+
+	// recsWithErrors counts every record with errors, including the ones not
+	// written because of the max records with errors limit
+	recsWithErrors int
+}
+
+// newDetailWriter clears the details file of the report key and keeps it
+// open until Close is called
+func newDetailWriter(outDir string, reportKey string, format string) (dw *detailWriter, err error) {
+	detailsFile, err := reportFilePath(filepath.Join(outDir, "details"), reportKey)
+	if err != nil {
+		return nil, err
+	}
+
+	dw = &detailWriter{ndjson: format == DetailsFormatNDJSON}
+	if dw.ndjson {
+		detailsFile = strings.TrimSuffix(detailsFile, ".json") + ".ndjson"
+	}
+
+	dw.f, err = os.Create(detailsFile)
+	if err != nil {
+		return nil, err
+	}
+	dw.w = bufio.NewWriter(dw.f)
+	dw.enc = json.NewEncoder(dw.w)
+
+	if dw.ndjson {
+		return dw, nil
+	}
+	dw.enc.SetIndent("  ", "  ")
+	if _, err := dw.w.WriteString("[\n"); err != nil {
+		dw.f.Close()
+		return nil, err
+	}
+	return dw, nil
+}
+
+// Write appends the record into the details file. In array mode the records
+// are indented and the commas lead the records following the first one, as
+// the encoder ends each record with a newline.
+func (dw *detailWriter) Write(rw RecordWrapper) (err error) {
+	if !dw.ndjson {
+		sep := "  "
+		if dw.written > 0 {
+			sep = ", "
+		}
+		if _, err := dw.w.WriteString(sep); err != nil {
+			return err
+		}
+	}
+	if err := dw.enc.Encode(rw); err != nil {
+		return err
+	}
+	dw.written++
+	return nil
+}
+
+// Close ends the JSON array when required, flushes and closes the details
+// file. It is safe to call Close more than once.
+func (dw *detailWriter) Close() (err error) {
+	if dw.f == nil {
+		return nil
+	}
+	defer func() {
+		if cerr := dw.f.Close(); err == nil {
+			err = cerr
+		}
+		dw.f = nil
+	}()
+
+	if !dw.ndjson {
+		if _, err := dw.w.WriteString("]\n"); err != nil {
+			return err
+		}
+	}
+	if err := dw.w.Flush(); err != nil {
+		return fmt.Errorf("cannot write details file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package qa
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/DataHenHQ/datahen/records"
+)
+
+func TestDetailWriter(t *testing.T) {
+	recs := []RecordWrapper{
+		{
+			Errors: []records.SchemaError{{Field: "id", ErrorType: "required", Description: "id is required"}},
+			Record: map[string]interface{}{"name": "<a & b>"},
+		},
+		{
+			Errors: []records.SchemaError{{Field: "id", ErrorType: "invalid_type", Description: "Invalid type. Expected: integer, given: string"}},
+			Record: map[string]interface{}{"id": "x", "tags": []interface{}{"a", "b"}},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		format string
+		recs   []RecordWrapper
+	}{
+		{"array", DetailsFormatJSON, recs},
+		{"empty array", DetailsFormatJSON, nil},
+		{"ndjson", DetailsFormatNDJSON, recs},
+		{"empty ndjson", DetailsFormatNDJSON, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outDir := t.TempDir()
+			dw, err := newDetailWriter(outDir, "data/products", tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, rw := range tt.recs {
+				if err := dw.Write(rw); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := dw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if err := dw.Close(); err != nil {
+				t.Errorf("got error %v closing twice", err)
+			}
+
+			// the details decode back into the records, by their JSON form
+			want := []interface{}{}
+			for _, rw := range tt.recs {
+				data, err := json.Marshal(rw)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var v interface{}
+				if err := json.Unmarshal(data, &v); err != nil {
+					t.Fatal(err)
+				}
+				want = append(want, v)
+			}
+
+			got := []interface{}{}
+			if tt.format == DetailsFormatNDJSON {
+				data, err := ioutil.ReadFile(filepath.Join(outDir, "details", "data", "products.ndjson"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				scanner := bufio.NewScanner(bytes.NewReader(data))
+				for scanner.Scan() {
+					var v interface{}
+					if err := json.Unmarshal(scanner.Bytes(), &v); err != nil {
+						t.Fatalf("got invalid NDJSON line %s: %v", scanner.Bytes(), err)
+					}
+					got = append(got, v)
+				}
+				if err := scanner.Err(); err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				data, err := ioutil.ReadFile(filepath.Join(outDir, "details", "data", "products.json"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := json.Unmarshal(data, &got); err != nil {
+					t.Fatalf("got invalid JSON array %s: %v", data, err)
+				}
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got details %v, want %v", got, want)
+			}
+		})
+	}
+}
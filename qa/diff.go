@@ -0,0 +1,185 @@
+package qa
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/DataHenHQ/henqa_shared/customtypes"
+)
+
+// Statuses of an error type compared against the baseline
+const (
+	DeltaNew       = "new"
+	DeltaResolved  = "resolved"
+	DeltaChanged   = "changed"
+	DeltaUnchanged = "unchanged"
+)
+
+// ErrorDelta compares the stats of a field.error_type of a file against the baseline.
+type ErrorDelta struct {
+	File       string  `json:"file"`
+	ErrorKey   string  `json:"error_key"`
+	Status     string  `json:"status"`
+	OldCount   uint64  `json:"old_error_count"`
+	NewCount   uint64  `json:"new_error_count"`
+	OldPercent float64 `json:"old_error_percent"`
+	NewPercent float64 `json:"new_error_percent"`
+	// Delta is the change of the error percentage, in percentage points.
+	Delta float64 `json:"delta"`
+}
+
+// SummaryDiff compares the summary of a validation against a baseline summary.
+// Files found in only one of them are listed but their errors aren't compared.
+type SummaryDiff struct {
+	Deltas       []ErrorDelta `json:"deltas"`
+	NewFiles     []string     `json:"new_files,omitempty"`
+	RemovedFiles []string     `json:"removed_files,omitempty"`
+}
+
+// DiffReports compares the summaries of two reports folders.
+func DiffReports(oldDir string, newDir string, summaryFile string) (d *SummaryDiff, err error) {
+	oldStats, err := readOverallSummaryFile(oldDir, summaryFile)
+	if err != nil {
+		return nil, err
+	}
+	newStats, err := readOverallSummaryFile(newDir, summaryFile)
+	if err != nil {
+		return nil, err
+	}
+	return DiffSummaries(oldStats, newStats), nil
+}
+
+// DiffSummaries compares the error stats of each file and field.error_type.
+func DiffSummaries(oldStats map[string]customtypes.ErrorStats, newStats map[string]customtypes.ErrorStats) (d *SummaryDiff) {
+	d = &SummaryDiff{Deltas: []ErrorDelta{}}
+
+	files := make([]string, 0, len(newStats))
+	for file := range newStats {
+		if _, ok := oldStats[file]; !ok {
+			d.NewFiles = append(d.NewFiles, file)
+			continue
+		}
+		files = append(files, file)
+	}
+	for file := range oldStats {
+		if _, ok := newStats[file]; !ok {
+			d.RemovedFiles = append(d.RemovedFiles, file)
+		}
+	}
+	sort.Strings(files)
+	sort.Strings(d.NewFiles)
+	sort.Strings(d.RemovedFiles)
+
+	for _, file := range files {
+		oldErrs, newErrs := oldStats[file], newStats[file]
+
+		keys := sortedErrKeys(newErrs)
+		for _, key := range sortedErrKeys(oldErrs) {
+			if _, ok := newErrs[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			delta := ErrorDelta{File: file, ErrorKey: key}
+			oldErr, newErr := oldErrs[key], newErrs[key]
+			if oldErr != nil {
+				delta.OldCount = oldErr.ErrorCount
+				delta.OldPercent = float64(oldErr.ErrorPercent)
+			}
+			if newErr != nil {
+				delta.NewCount = newErr.ErrorCount
+				delta.NewPercent = float64(newErr.ErrorPercent)
+			}
+			delta.Delta = delta.NewPercent - delta.OldPercent
+
+			switch {
+			case delta.OldCount == 0 && delta.NewCount > 0:
+				delta.Status = DeltaNew
+			case delta.OldCount > 0 && delta.NewCount == 0:
+				delta.Status = DeltaResolved
+			case delta.OldCount != delta.NewCount || delta.Delta != 0:
+				delta.Status = DeltaChanged
+			default:
+				delta.Status = DeltaUnchanged
+			}
+			d.Deltas = append(d.Deltas, delta)
+		}
+	}
+	return d
+}
+
+// Regressions returns the error types whose percentage increased by more than
+// maxIncrease percentage points, new error types included. A negative
+// maxIncrease returns none.
+func (d *SummaryDiff) Regressions(maxIncrease float64) (deltas []ErrorDelta) {
+	if d == nil || maxIncrease < 0 {
+		return nil
+	}
+	for _, delta := range d.Deltas {
+		if delta.Delta > maxIncrease {
+			deltas = append(deltas, delta)
+		}
+	}
+	return deltas
+}
+
+// CheckRegressions returns a *ThresholdError when an error percentage
+// increased by more than maxIncrease percentage points. A negative
+// maxIncrease disables the check.
+func CheckRegressions(d *SummaryDiff, maxIncrease float64) error {
+	violations := regressionViolations(d, maxIncrease)
+	if len(violations) > 0 {
+		return &ThresholdError{Violations: violations}
+	}
+	return nil
+}
+
+func regressionViolations(d *SummaryDiff, maxIncrease float64) (violations []string) {
+	for _, delta := range d.Regressions(maxIncrease) {
+		violations = append(violations, fmt.Sprintf("%v: %v error percent increased from %v%% to %v%%, over %v points", delta.File, delta.ErrorKey, delta.OldPercent, delta.NewPercent, maxIncrease))
+	}
+	return violations
+}
+
+// WriteSummaryDiff writes the changed, new and resolved error types of each
+// file as text, unchanged ones are left out.
+func WriteSummaryDiff(w io.Writer, d *SummaryDiff) (err error) {
+	var b strings.Builder
+	file := ""
+	changes := 0
+	for _, delta := range d.Deltas {
+		if delta.Status == DeltaUnchanged {
+			continue
+		}
+		if delta.File != file {
+			file = delta.File
+			fmt.Fprintf(&b, "%v:\n", file)
+		}
+		changes++
+
+		switch delta.Status {
+		case DeltaNew:
+			fmt.Fprintf(&b, "  + %v: %v errors, %.2f%% (new)\n", delta.ErrorKey, delta.NewCount, delta.NewPercent)
+		case DeltaResolved:
+			fmt.Fprintf(&b, "  - %v: %v errors, %.2f%% (resolved)\n", delta.ErrorKey, delta.OldCount, delta.OldPercent)
+		default:
+			fmt.Fprintf(&b, "  ~ %v: %v -> %v errors, %.2f%% -> %.2f%% (%+.2f)\n", delta.ErrorKey, delta.OldCount, delta.NewCount, delta.OldPercent, delta.NewPercent, delta.Delta)
+		}
+	}
+	if changes == 0 {
+		b.WriteString("no error changes\n")
+	}
+	for _, f := range d.NewFiles {
+		fmt.Fprintf(&b, "new file: %v\n", f)
+	}
+	for _, f := range d.RemovedFiles {
+		fmt.Fprintf(&b, "removed file: %v\n", f)
+	}
+
+	_, err = io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,209 @@
+package qa
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DataHenHQ/henqa_shared/customtypes"
+)
+
+// testErrStat is the stat of count errors out of recordCount records
+func testErrStat(count uint64, recordCount uint64) *customtypes.ErrorStat {
+	es := &customtypes.ErrorStat{ErrorCount: count, RecordCount: recordCount}
+	es.CalculatePercentage()
+	return es
+}
+
+func TestDiffSummaries(t *testing.T) {
+	oldStats := map[string]customtypes.ErrorStats{
+		"a.json": {
+			"id.required":  testErrStat(10, 100),
+			"name.type":    testErrStat(5, 100),
+			"price.min":    testErrStat(1, 100),
+			"sku.required": testErrStat(2, 100),
+		},
+		"removed.json": {"id.required": testErrStat(1, 10)},
+		"same.json":    {},
+	}
+	newStats := map[string]customtypes.ErrorStats{
+		"a.json": {
+			"id.required":  testErrStat(20, 100),
+			"price.min":    testErrStat(1, 100),
+			"sku.required": testErrStat(2, 200),
+			"url.format":   testErrStat(3, 100),
+		},
+		"added.json": {"id.required": testErrStat(1, 10)},
+		"same.json":  {},
+	}
+
+	d := DiffSummaries(oldStats, newStats)
+	if !reflect.DeepEqual(d.NewFiles, []string{"added.json"}) || !reflect.DeepEqual(d.RemovedFiles, []string{"removed.json"}) {
+		t.Errorf("got new files %v and removed files %v", d.NewFiles, d.RemovedFiles)
+	}
+
+	want := []ErrorDelta{
+		{File: "a.json", ErrorKey: "id.required", Status: DeltaChanged, OldCount: 10, NewCount: 20, OldPercent: 10, NewPercent: 20, Delta: 10},
+		{File: "a.json", ErrorKey: "name.type", Status: DeltaResolved, OldCount: 5, OldPercent: 5, Delta: -5},
+		{File: "a.json", ErrorKey: "price.min", Status: DeltaUnchanged, OldCount: 1, NewCount: 1, OldPercent: 1, NewPercent: 1},
+		{File: "a.json", ErrorKey: "sku.required", Status: DeltaChanged, OldCount: 2, NewCount: 2, OldPercent: 2, NewPercent: 1, Delta: -1},
+		{File: "a.json", ErrorKey: "url.format", Status: DeltaNew, NewCount: 3, NewPercent: 3, Delta: 3},
+	}
+	if !reflect.DeepEqual(d.Deltas, want) {
+		t.Errorf("got deltas %+v, want %+v", d.Deltas, want)
+	}
+
+	tests := []struct {
+		maxIncrease float64
+		want        []string
+	}{
+		{-1, nil},
+		{0, []string{"id.required", "url.format"}},
+		{3, []string{"id.required"}},
+		{10, nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, delta := range d.Regressions(tt.maxIncrease) {
+			got = append(got, delta.ErrorKey)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("got regressions %v over %v points, want %v", got, tt.maxIncrease, tt.want)
+		}
+	}
+}
+
+func TestWriteSummaryDiff(t *testing.T) {
+	d := DiffSummaries(
+		map[string]customtypes.ErrorStats{
+			"a.json":       {"id.required": testErrStat(10, 100), "name.type": testErrStat(5, 100), "price.min": testErrStat(1, 100)},
+			"removed.json": {},
+		},
+		map[string]customtypes.ErrorStats{
+			"a.json":     {"id.required": testErrStat(20, 100), "price.min": testErrStat(1, 100), "url.format": testErrStat(3, 100)},
+			"added.json": {},
+		},
+	)
+
+	var b strings.Builder
+	if err := WriteSummaryDiff(&b, d); err != nil {
+		t.Fatal(err)
+	}
+	want := `a.json:
+  ~ id.required: 10 -> 20 errors, 10.00% -> 20.00% (+10.00)
+  - name.type: 5 errors, 5.00% (resolved)
+  + url.format: 3 errors, 3.00% (new)
+new file: added.json
+removed file: removed.json
+`
+	if b.String() != want {
+		t.Errorf("got diff:\n%v\nwant:\n%v", b.String(), want)
+	}
+
+	b.Reset()
+	if err := WriteSummaryDiff(&b, DiffSummaries(nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != "no error changes\n" {
+		t.Errorf("got diff %q without changes", b.String())
+	}
+}
+
+func TestCheckThresholdsMaxErrorIncrease(t *testing.T) {
+	result := &ValidationResult{
+		Files: map[string]*FileResult{
+			"a.json": {RecordCount: 100, ErrorStats: map[string]*customtypes.ErrorStat{"id.required": testErrStat(20, 100)}},
+		},
+		Baseline: DiffSummaries(
+			map[string]customtypes.ErrorStats{"a.json": {"id.required": testErrStat(10, 100)}},
+			map[string]customtypes.ErrorStats{"a.json": {"id.required": testErrStat(20, 100)}},
+		),
+	}
+
+	tests := []struct {
+		maxIncrease float64
+		fails       bool
+	}{
+		{-1, false},
+		{0, true},
+		{9.5, true},
+		{10, false},
+		{50, false},
+	}
+	for _, tt := range tests {
+		err := CheckThresholds(result, Thresholds{MaxErrorPercent: -1, MaxErrorIncrease: tt.maxIncrease})
+		var te *ThresholdError
+		if tt.fails != errors.As(err, &te) {
+			t.Errorf("got error %v with a max increase of %v, want failing %v", err, tt.maxIncrease, tt.fails)
+			continue
+		}
+		if tt.fails && (len(te.Violations) != 1 || !strings.Contains(te.Violations[0], "a.json: id.required error percent increased from 10% to 20%")) {
+			t.Errorf("got violations %v", te.Violations)
+		}
+		if err := CheckRegressions(result.Baseline, tt.maxIncrease); (err != nil) != tt.fails {
+			t.Errorf("got regressions error %v with a max increase of %v, want failing %v", err, tt.maxIncrease, tt.fails)
+		}
+	}
+}
+
+func TestRunBaseline(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"old/products.ndjson": "{\"id\": 1}\n{\"id\": 2}\n{\"id\": \"x\"}\n{\"id\": 4}\n",
+		"old/removed.ndjson":  "{\"id\": 1}\n",
+		"new/products.ndjson": "{\"id\": 1}\n{\"id\": \"y\"}\n{\"id\": \"x\"}\n{\"name\": \"d\"}\n",
+		"new/added.ndjson":    "{\"id\": 1}\n",
+	})
+
+	baseline := newTestOptions(t, dir, filepath.Join(dir, "old"))
+	if _, err := NewValidator(baseline).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := newTestOptions(t, dir, filepath.Join(dir, "new"))
+	opts.Baseline = baseline.OutDir
+	result, err := NewValidator(opts).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := result.Baseline
+	if d == nil {
+		t.Fatal("got no baseline comparison")
+	}
+	if !reflect.DeepEqual(d.NewFiles, []string{"added.ndjson"}) || !reflect.DeepEqual(d.RemovedFiles, []string{"removed.ndjson"}) {
+		t.Errorf("got new files %v and removed files %v", d.NewFiles, d.RemovedFiles)
+	}
+	// the missing id is new and the invalid ids went from 1 to 2
+	statuses := map[string]ErrorDelta{}
+	for _, delta := range d.Deltas {
+		if delta.File != "products.ndjson" {
+			t.Errorf("got delta %+v of a file not in both runs", delta)
+		}
+		statuses[delta.Status] = delta
+	}
+	if len(d.Deltas) != 2 || statuses[DeltaNew].NewCount != 1 || statuses[DeltaChanged].OldCount != 1 || statuses[DeltaChanged].NewCount != 2 {
+		t.Errorf("got deltas %+v, want a new error type and a changed one", d.Deltas)
+	}
+
+	// henqa diff of the reports finds the same changes
+	saved, err := DiffReports(baseline.OutDir, opts.OutDir, "summary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(saved, d) {
+		t.Errorf("got diff %+v of the reports, want %+v", saved, d)
+	}
+	if err := CheckThresholds(result, Thresholds{MaxErrorPercent: -1, MaxErrorIncrease: 10}); err == nil {
+		t.Error("got the thresholds passing with a 25 points increase")
+	}
+
+	// a missing baseline fails before validating
+	opts.Baseline = filepath.Join(dir, "missing")
+	if _, err := NewValidator(opts).Run(context.Background()); err == nil {
+		t.Error("got no error with a missing baseline")
+	}
+}
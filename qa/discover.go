@@ -0,0 +1,235 @@
+package qa
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+// IgnoreFileName is the name of the file listing the patterns of the files
+// to ignore in a directory and its subdirectories, in the style of .gitignore
+const IgnoreFileName = ".henqaignore"
+
+// ValidatePatterns returns an error on the first malformed glob pattern
+func ValidatePatterns(patterns []string) (err error) {
+	for _, p := range patterns {
+		if !doublestar.ValidatePattern(p) {
+			return fmt.Errorf("invalid glob pattern %q", p)
+		}
+	}
+	return nil
+}
+
+// ignorePattern is a pattern of an ignore file
+type ignorePattern struct {
+	pattern string
+	negate  bool
+	dirOnly bool
+}
+
+// ignoreRules are the patterns of an ignore file, relative to its directory
+type ignoreRules struct {
+	base     string
+	patterns []ignorePattern
+}
+
+// readIgnoreFile reads the ignore file of dir, if any. Patterns without a
+// slash match at any depth, a trailing slash matches directories only and a
+// leading ! re-includes what a previous pattern ignored.
+func readIgnoreFile(dir string, base string) (rules *ignoreRules, err error) {
+	file, err := os.Open(filepath.Join(dir, IgnoreFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rules = &ignoreRules{base: base}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		ip := ignorePattern{}
+		if strings.HasPrefix(line, "!") {
+			ip.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			ip.dirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		if strings.HasPrefix(line, "/") {
+			line = strings.TrimPrefix(line, "/")
+		} else if !strings.Contains(line, "/") {
+			line = "**/" + line
+		}
+		if line == "" || !doublestar.ValidatePattern(line) {
+			continue
+		}
+		ip.pattern = line
+		rules.patterns = append(rules.patterns, ip)
+	}
+	return rules, scanner.Err()
+}
+
+// isIgnored tells whether the ignore files ignore the path, relative to the
+// walked input directory. The last matching pattern wins.
+func isIgnored(ignores []*ignoreRules, rel string, isDir bool) (ignored bool) {
+	for _, rules := range ignores {
+		p := rel
+		if rules.base != "" {
+			if !strings.HasPrefix(rel, rules.base+"/") {
+				continue
+			}
+			p = strings.TrimPrefix(rel, rules.base+"/")
+		}
+		for _, ip := range rules.patterns {
+			if ip.dirOnly && !isDir {
+				continue
+			}
+			if ok, _ := doublestar.Match(ip.pattern, p); ok {
+				ignored = !ip.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// isHidden tells whether any element of the slash separated path is a dotfile
+func isHidden(rel string) bool {
+	for _, name := range strings.Split(rel, "/") {
+		if strings.HasPrefix(name, ".") && name != "." && name != ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAny tells whether the path matches any of the patterns
+func matchesAny(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		if ok, _ := doublestar.Match(p, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// skipPath tells whether the directory, archive or file at the relative path
+// is left out of the inputs by the hidden and exclude filters
+func (v *Validator) skipPath(rel string) bool {
+	if !v.opts.Hidden && isHidden(rel) {
+		return true
+	}
+	return matchesAny(v.opts.Exclude, rel)
+}
+
+// includeFile tells whether the file at the relative path is an input
+func (v *Validator) includeFile(rel string) bool {
+	if v.skipPath(rel) {
+		return false
+	}
+	return len(v.opts.Include) == 0 || matchesAny(v.opts.Include, rel)
+}
+
+// getFilesFromDir lists the input files of dir, applying the filters to the
+// paths relative to dir and honouring the ignore files found along the way.
+// Unsupported files, such as a README, are left out. The directories and
+// archives that cannot be read are returned as unreadable.
+func (v *Validator) getFilesFromDir(dir string) (files []string, unreadable []string) {
+	return v.walkDir(dir, "", nil)
+}
+
+func (v *Validator) walkDir(root string, rel string, ignores []*ignoreRules) (files []string, unreadable []string) {
+	dir := filepath.Join(root, filepath.FromSlash(rel))
+
+	rules, err := readIgnoreFile(dir, rel)
+	if err != nil {
+		v.logln("gotten error reading ", filepath.Join(dir, IgnoreFileName), ":", err.Error())
+	}
+	if rules != nil {
+		ignores = append(ignores[:len(ignores):len(ignores)], rules)
+	}
+
+	fs, err := ioutil.ReadDir(dir)
+	if err != nil {
+		v.logln("gotten error reading ", dir, ":", err.Error())
+		unreadable = append(unreadable, dir)
+	}
+	for _, f := range fs {
+		subRel := path.Join(rel, f.Name())
+		subPath := filepath.Join(dir, f.Name())
+
+		if f.IsDir() {
+			if v.opts.NoRecursive || v.skipPath(subRel) || isIgnored(ignores, subRel, true) {
+				continue
+			}
+			subFiles, subUnreadable := v.walkDir(root, subRel, ignores)
+			files = append(files, subFiles...)
+			unreadable = append(unreadable, subUnreadable...)
+			continue
+		}
+
+		if f.Name() == IgnoreFileName || isIgnored(ignores, subRel, false) {
+			continue
+		}
+
+		// archives are walked like directories, so only the exclude filters apply to them
+		if archiveFormat(f.Name()) != archiveNone {
+			if v.skipPath(subRel) {
+				continue
+			}
+			members, err := v.getFilesFromArchive(subPath)
+			if err != nil {
+				unreadable = append(unreadable, subPath)
+			}
+			files = append(files, members...)
+			continue
+		}
+
+		if !v.includeFile(subRel) {
+			continue
+		}
+		if !isInputFile(f.Name()) {
+			v.logf("skipping unsupported file: %v\n", subPath)
+			continue
+		}
+		files = append(files, subPath)
+	}
+	return files, unreadable
+}
+
+// getFilesFromArchive lists the input files inside the archive, applying the
+// filters to the member paths and leaving out the unsupported files
+func (v *Validator) getFilesFromArchive(archive string) (files []string, err error) {
+	members, err := listArchiveMembers(archive)
+	if err != nil {
+		v.logln("gotten error reading ", archive, ":", err.Error())
+		return nil, err
+	}
+
+	for _, m := range members {
+		rel, err := filepath.Rel(archive, m)
+		if err != nil || !v.includeFile(filepath.ToSlash(rel)) {
+			continue
+		}
+		if !isInputFile(m) {
+			v.logf("skipping unsupported file: %v\n", m)
+			continue
+		}
+		files = append(files, m)
+	}
+	v.logf("archive %v has %d files\n", archive, len(files))
+	return files, nil
+}
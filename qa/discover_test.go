@@ -0,0 +1,138 @@
+package qa
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// discoverTestFiles lists the input files of dir with the options, relative to dir
+func discoverTestFiles(t *testing.T, dir string, opts Options) (files []string) {
+	t.Helper()
+	opts.Logger = ioutil.Discard
+	found, unreadable := NewValidator(opts).getFilesFromDir(dir)
+	if len(unreadable) > 0 {
+		t.Fatalf("got unreadable paths %v", unreadable)
+	}
+	for _, f := range found {
+		rel, err := filepath.Rel(dir, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, filepath.ToSlash(rel))
+	}
+	return files
+}
+
+func TestGetFilesFromDirIgnoreFile(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		IgnoreFileName: `# generated files
+*.tmp.json
+!keep.tmp.json
+
+old*/
+/top.json
+logs
+!logs/keep.json
+`,
+		"a.json":            "[]",
+		"x.tmp.json":        "[]",
+		"keep.tmp.json":     "[]",
+		"top.json":          "[]",
+		"old.json":          "[]",
+		"old/a.json":        "[]",
+		"logs/keep.json":    "[]",
+		"sub/y.tmp.json":    "[]",
+		"sub/keep.tmp.json": "[]",
+		"sub/top.json":      "[]",
+		"sub/older/a.json":  "[]",
+		"nested/" + IgnoreFileName: `!*.tmp.json
+a.json
+`,
+		"nested/a.json":     "[]",
+		"nested/b.json":     "[]",
+		"nested/z.tmp.json": "[]",
+		"nested/in/a.json":  "[]",
+		"nested/in/c.json":  "[]",
+	})
+
+	// a negation re-includes what an earlier pattern ignored but not the files
+	// of an ignored directory, directory patterns leave the files of the same
+	// name, anchored patterns only match next to their ignore file and the
+	// ignore files of subdirectories apply after the ones of their parents
+	got := discoverTestFiles(t, dir, Options{})
+	want := []string{
+		"a.json",
+		"keep.tmp.json",
+		"nested/b.json",
+		"nested/in/c.json",
+		"nested/z.tmp.json",
+		"old.json",
+		"sub/keep.tmp.json",
+		"sub/top.json",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got files %v, want %v", got, want)
+	}
+}
+
+func TestGetFilesFromDirIgnoreFileAndFilters(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		IgnoreFileName: `*.bak.json
+!important.bak.json
+!skip/*.json
+`,
+		"a.json":                 "[]",
+		"a.bak.json":             "[]",
+		"important.bak.json":     "[]",
+		"skip/a.json":            "[]",
+		"skip/" + IgnoreFileName: "!*.json\n",
+		".hidden/a.json":         "[]",
+		".hidden/b.bak.json":     "[]",
+		"data/" + IgnoreFileName: "b.json\n",
+		"data/b.json":            "[]",
+		"data/c.json":            "[]",
+		"data/c.csv":             "id\n1\n",
+	})
+
+	tests := []struct {
+		name string
+		opts Options
+		want []string
+	}{
+		{
+			name: "ignore file only",
+			want: []string{"a.json", "data/c.csv", "data/c.json", "important.bak.json", "skip/a.json"},
+		},
+		{
+			// --exclude applies before the ignore files, their negations
+			// cannot bring the excluded files and directories back
+			name: "exclude",
+			opts: Options{Exclude: []string{"**/important*", "skip"}},
+			want: []string{"a.json", "data/c.csv", "data/c.json"},
+		},
+		{
+			// --include cannot bring back the files of the ignore files
+			name: "include",
+			opts: Options{Include: []string{"**/*.json"}},
+			want: []string{"a.json", "data/c.json", "important.bak.json", "skip/a.json"},
+		},
+		{
+			// dot directories still follow the ignore files, which are never inputs
+			name: "hidden",
+			opts: Options{Hidden: true},
+			want: []string{".hidden/a.json", "a.json", "data/c.csv", "data/c.json", "important.bak.json", "skip/a.json"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := discoverTestFiles(t, dir, tt.opts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got files %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
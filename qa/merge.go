@@ -0,0 +1,220 @@
+package qa
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+)
+
+// Strategies combining multiple schema files
+const (
+	// MergeStrategyMergePatch merges the later schemas as JSON Merge Patches
+	// (RFC 7386), replacing arrays such as required wholesale.
+	MergeStrategyMergePatch = "merge-patch"
+	// MergeStrategyDeep merges objects recursively and extends arrays with the
+	// items they don't have yet. Replacing an object or by an object fails.
+	MergeStrategyDeep = "deep"
+	// MergeStrategyJSONPatch applies the later files as JSON Patches (RFC 6902)
+	// to the first schema.
+	MergeStrategyJSONPatch = "json-patch"
+	// MergeStrategyAllOf requires the records to be valid against every
+	// schema, composing them with allOf.
+	MergeStrategyAllOf = "allof"
+)
+
+// ValidateMergeStrategy returns an error when the merge strategy is unknown
+func ValidateMergeStrategy(strategy string) (err error) {
+	switch strategy {
+	case MergeStrategyMergePatch, MergeStrategyDeep, MergeStrategyJSONPatch, MergeStrategyAllOf:
+		return nil
+	}
+	return fmt.Errorf("unknown merge strategy %q, expected %v, %v, %v or %v", strategy, MergeStrategyMergePatch, MergeStrategyDeep, MergeStrategyJSONPatch, MergeStrategyAllOf)
+}
+
+// mergeSchemas combines the schemas of the files in order with the strategy
+func mergeSchemas(strategy string, files []string, schemas [][]byte) (schema []byte, err error) {
+	if len(schemas) == 0 {
+		return nil, nil
+	}
+	if len(schemas) == 1 {
+		return schemas[0], nil
+	}
+
+	switch strategy {
+	case MergeStrategyMergePatch, "":
+		schema = schemas[0]
+		for _, nschema := range schemas[1:] {
+			schema, err = jsonpatch.MergePatch(schema, nschema)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return schema, nil
+
+	case MergeStrategyDeep:
+		var merged interface{}
+		for i, s := range schemas {
+			doc, err := decodeJSON(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid schema %v: %v", files[i], err)
+			}
+			if i == 0 {
+				merged = doc
+				continue
+			}
+			merged, err = deepMerge(merged, doc, "")
+			if err != nil {
+				return nil, fmt.Errorf("cannot merge schema %v: %v", files[i], err)
+			}
+		}
+		return json.Marshal(merged)
+
+	case MergeStrategyJSONPatch:
+		schema = schemas[0]
+		for i, p := range schemas[1:] {
+			patch, err := jsonpatch.DecodePatch(p)
+			if err != nil {
+				return nil, fmt.Errorf("%v is not a JSON Patch: %v", files[i+1], err)
+			}
+			schema, err = patch.Apply(schema)
+			if err != nil {
+				return nil, fmt.Errorf("cannot apply JSON Patch %v: %v", files[i+1], err)
+			}
+		}
+		return schema, nil
+
+	case MergeStrategyAllOf:
+		return composeAllOf(files, schemas)
+	}
+	return nil, ValidateMergeStrategy(strategy)
+}
+
+// deepMerge merges the later value into the base one. Objects are merged
+// recursively, arrays get the items they don't have yet, null removes the
+// key and other values replace the base value. An object never replaces
+// another value or gets replaced, as such conflicts are mistakes rather than
+// overrides.
+func deepMerge(base interface{}, later interface{}, ptr string) (merged interface{}, err error) {
+	bv, baseIsObject := base.(map[string]interface{})
+	lv, laterIsObject := later.(map[string]interface{})
+	if base != nil && baseIsObject != laterIsObject {
+		if ptr == "" {
+			ptr = "/"
+		}
+		return nil, fmt.Errorf("cannot merge %v into %v at %v", jsonKind(later), jsonKind(base), ptr)
+	}
+
+	switch {
+	case laterIsObject && baseIsObject:
+		for k, v := range lv {
+			if v == nil {
+				delete(bv, k)
+				continue
+			}
+			if existing, ok := bv[k]; ok {
+				bv[k], err = deepMerge(existing, v, ptr+"/"+escapePointer(k))
+				if err != nil {
+					return nil, err
+				}
+				continue
+			}
+			bv[k] = v
+		}
+		return bv, nil
+	case laterIsObject:
+		return lv, nil
+	}
+
+	if la, ok := later.([]interface{}); ok {
+		ba, ok := base.([]interface{})
+		if !ok {
+			return la, nil
+		}
+		for _, item := range la {
+			if !containsValue(ba, item) {
+				ba = append(ba, item)
+			}
+		}
+		return ba, nil
+	}
+	return later, nil
+}
+
+// jsonKind names the JSON type of the decoded value
+func jsonKind(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	case nil:
+		return "null"
+	}
+	return "a number"
+}
+
+func containsValue(arr []interface{}, v interface{}) bool {
+	for _, item := range arr {
+		if reflect.DeepEqual(item, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// composeAllOf wraps the schemas into an allOf. Their $id and $schema move to
+// the root and their local references are rebased on their allOf location.
+func composeAllOf(files []string, schemas [][]byte) (schema []byte, err error) {
+	root := map[string]interface{}{}
+	allOf := make([]interface{}, 0, len(schemas))
+
+	for i, s := range schemas {
+		doc, err := decodeJSON(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schema %v: %v", files[i], err)
+		}
+
+		if m, ok := doc.(map[string]interface{}); ok {
+			for _, k := range []string{"$schema", "$id", "id"} {
+				if v, ok := m[k]; ok {
+					if _, exists := root[k]; !exists {
+						root[k] = v
+					}
+					delete(m, k)
+				}
+			}
+		}
+		allOf = append(allOf, rebaseLocalRefs(doc, fmt.Sprintf("/allOf/%d", i)))
+	}
+	root["allOf"] = allOf
+
+	return json.Marshal(root)
+}
+
+// rebaseLocalRefs prefixes the JSON pointers of the local references
+func rebaseLocalRefs(node interface{}, prefix string) interface{} {
+	switch val := node.(type) {
+	case map[string]interface{}:
+		for k, v := range val {
+			if ref, ok := v.(string); ok && k == "$ref" {
+				if ref == "#" || strings.HasPrefix(ref, "#/") {
+					val[k] = "#" + prefix + ref[1:]
+				}
+				continue
+			}
+			val[k] = rebaseLocalRefs(v, prefix)
+		}
+	case []interface{}:
+		for i, v := range val {
+			val[i] = rebaseLocalRefs(v, prefix)
+		}
+	}
+	return node
+}
@@ -0,0 +1,199 @@
+package qa
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergeSchemas(t *testing.T) {
+	base := `{"type": "object", "required": ["id"], "properties": {"id": {"type": "integer"}, "name": {"type": "string"}}}`
+
+	tests := []struct {
+		name     string
+		strategy string
+		schemas  []string
+		want     string
+		err      string
+	}{
+		{
+			name:     "single schema",
+			strategy: MergeStrategyDeep,
+			schemas:  []string{base},
+			want:     base,
+		},
+		{
+			name:     "merge patch",
+			strategy: MergeStrategyMergePatch,
+			schemas: []string{
+				base,
+				`{"required": ["name"], "properties": {"name": null, "price": {"type": "number"}}}`,
+			},
+			want: `{"type": "object", "required": ["name"], "properties": {"id": {"type": "integer"}, "price": {"type": "number"}}}`,
+		},
+		{
+			name:     "default strategy",
+			strategy: "",
+			schemas:  []string{base, `{"properties": {"id": {"type": "string"}}}`},
+			want:     `{"type": "object", "required": ["id"], "properties": {"id": {"type": "string"}, "name": {"type": "string"}}}`,
+		},
+		{
+			name:     "merge patch of three schemas",
+			strategy: MergeStrategyMergePatch,
+			schemas:  []string{base, `{"additionalProperties": false}`, `{"additionalProperties": true, "type": "object"}`},
+			want:     `{"type": "object", "required": ["id"], "additionalProperties": true, "properties": {"id": {"type": "integer"}, "name": {"type": "string"}}}`,
+		},
+		{
+			name:     "merge patch type change",
+			strategy: MergeStrategyMergePatch,
+			schemas:  []string{base, `{"properties": "none"}`},
+			want:     `{"type": "object", "required": ["id"], "properties": "none"}`,
+		},
+		{
+			name:     "deep",
+			strategy: MergeStrategyDeep,
+			schemas: []string{
+				base,
+				`{"required": ["name", "id"], "properties": {"name": {"maxLength": 10}, "tags": {"type": "array"}}}`,
+			},
+			want: `{"type": "object", "required": ["id", "name"], "properties": {"id": {"type": "integer"}, "name": {"type": "string", "maxLength": 10}, "tags": {"type": "array"}}}`,
+		},
+		{
+			name:     "deep removal and replacement",
+			strategy: MergeStrategyDeep,
+			schemas:  []string{base, `{"properties": {"id": {"type": ["integer", "string"]}, "name": null}}`},
+			want:     `{"type": "object", "required": ["id"], "properties": {"id": {"type": ["integer", "string"]}}}`,
+		},
+		{
+			name:     "deep object replaced",
+			strategy: MergeStrategyDeep,
+			schemas:  []string{base, `{"properties": {"id": "integer"}}`},
+			err:      "cannot merge schema b.json: cannot merge a string into an object at /properties/id",
+		},
+		{
+			name:     "deep replaced by an object",
+			strategy: MergeStrategyDeep,
+			schemas:  []string{base, `{"required": {"id": true}}`},
+			err:      "cannot merge an object into an array at /required",
+		},
+		{
+			name:     "deep invalid schema",
+			strategy: MergeStrategyDeep,
+			schemas:  []string{base, `{"properties": `},
+			err:      "invalid schema b.json",
+		},
+		{
+			name:     "json patch",
+			strategy: MergeStrategyJSONPatch,
+			schemas: []string{
+				base,
+				`[{"op": "add", "path": "/required/-", "value": "name"}, {"op": "remove", "path": "/properties/id"}]`,
+				`[{"op": "replace", "path": "/type", "value": ["object", "null"]}]`,
+			},
+			want: `{"type": ["object", "null"], "required": ["id", "name"], "properties": {"name": {"type": "string"}}}`,
+		},
+		{
+			name:     "json patch test",
+			strategy: MergeStrategyJSONPatch,
+			schemas:  []string{base, `[{"op": "test", "path": "/type", "value": "array"}]`},
+			err:      "cannot apply JSON Patch b.json",
+		},
+		{
+			name:     "json patch bad op",
+			strategy: MergeStrategyJSONPatch,
+			schemas:  []string{base, `[{"op": "rename", "path": "/type"}]`},
+			err:      "cannot apply JSON Patch b.json",
+		},
+		{
+			name:     "json patch missing path",
+			strategy: MergeStrategyJSONPatch,
+			schemas:  []string{base, `[{"op": "remove", "path": "/properties/price"}]`},
+			err:      "cannot apply JSON Patch b.json",
+		},
+		{
+			name:     "json patch type conflict",
+			strategy: MergeStrategyJSONPatch,
+			schemas:  []string{base, `[{"op": "add", "path": "/type/format", "value": "x"}]`},
+			err:      "cannot apply JSON Patch b.json",
+		},
+		{
+			name:     "not a json patch",
+			strategy: MergeStrategyJSONPatch,
+			schemas:  []string{base, `{"op": "add"}`},
+			err:      "b.json is not a JSON Patch",
+		},
+		{
+			name:     "unknown strategy",
+			strategy: "union",
+			schemas:  []string{base, base},
+			err:      `unknown merge strategy "union"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files := []string{"a.json", "b.json", "c.json"}[:len(tt.schemas)]
+			schemas := make([][]byte, len(tt.schemas))
+			for i, s := range tt.schemas {
+				schemas[i] = []byte(s)
+			}
+
+			got, err := mergeSchemas(tt.strategy, files, schemas)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var gotDoc, wantDoc interface{}
+			if err := json.Unmarshal(got, &gotDoc); err != nil {
+				t.Fatalf("got invalid schema %s: %v", got, err)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &wantDoc); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(gotDoc, wantDoc) {
+				t.Errorf("got schema %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComposeAllOf(t *testing.T) {
+	schemas := [][]byte{
+		[]byte(`{"$schema": "http://json-schema.org/draft-07/schema#", "$id": "product", "properties": {"id": {"$ref": "#/definitions/id"}}, "definitions": {"id": {"type": "integer"}}}`),
+		[]byte(`{"$id": "stock", "required": ["id"], "properties": {"stock": {"$ref": "other.json#/definitions/count"}}}`),
+	}
+	got, err := mergeSchemas(MergeStrategyAllOf, []string{"product.json", "stock.json"}, schemas)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var gotDoc, wantDoc interface{}
+	if err := json.Unmarshal(got, &gotDoc); err != nil {
+		t.Fatal(err)
+	}
+	want := `{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"$id": "product",
+		"allOf": [
+			{"properties": {"id": {"$ref": "#/allOf/0/definitions/id"}}, "definitions": {"id": {"type": "integer"}}},
+			{"required": ["id"], "properties": {"stock": {"$ref": "other.json#/definitions/count"}}}
+		]
+	}`
+	if err := json.Unmarshal([]byte(want), &wantDoc); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(gotDoc, wantDoc) {
+		t.Errorf("got schema %s, want %s", got, want)
+	}
+
+	if _, err := mergeSchemas(MergeStrategyAllOf, []string{"a.json", "b.json"}, [][]byte{schemas[0], []byte("{")}); err == nil || !strings.Contains(err.Error(), "invalid schema b.json") {
+		t.Errorf("got error %v, want an invalid schema", err)
+	}
+}
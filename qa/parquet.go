@@ -0,0 +1,371 @@
+package qa
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/DataHenHQ/datahen/records"
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/common"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/types"
+)
+
+// parquetColumn is a leaf column of a parquet file and where its values go in a record
+type parquetColumn struct {
+	inPath   string
+	path     []string
+	element  *parquet.SchemaElement
+	repeated bool
+	// defLevels are the definition levels of the path elements of the
+	// columns that are not repeated, telling which group is null
+	defLevels []int32
+}
+
+// processParquetFile reads the rows of a parquet file as records, column by
+// column for each batch. Nested groups become nested objects and repeated
+// columns become arrays.
+func processParquetFile(filename string, batchSize int, configReaderFn records.ConfigReaderFn, validateFn records.ValidateFn, validatePreRecords records.ValidateHeadersFn) (err error) {
+	fr, err := local.NewLocalFileReader(filename)
+	if err != nil {
+		return err
+	}
+	defer fr.Close()
+
+	pr, err := reader.NewParquetColumnReader(fr, 1)
+	if err != nil {
+		return fmt.Errorf("cannot read parquet file %v: %v", filename, err)
+	}
+	defer pr.ReadStop()
+
+	columns, headers, err := parquetColumns(pr)
+	if err != nil {
+		return err
+	}
+
+	// execute the headers hook using the top level column names
+	if validatePreRecords != nil {
+		stop, err := validatePreRecords(headers)
+		if err != nil {
+			return err
+		}
+		if stop {
+			return nil
+		}
+	}
+
+	batcher := newRecordBatcher(batchSize, validateFn)
+	numRows := pr.GetNumRows()
+	for read := int64(0); read < numRows; read += int64(batchSize) {
+		n := int64(batchSize)
+		if numRows-read < n {
+			n = numRows - read
+		}
+
+		rows := make([]map[string]interface{}, n)
+		for i := range rows {
+			rows[i] = map[string]interface{}{}
+		}
+		for _, col := range columns {
+			if err := readParquetColumn(pr, col, rows); err != nil {
+				return fmt.Errorf("cannot read parquet column %v: %v", strings.Join(col.path, "."), err)
+			}
+		}
+
+		for _, row := range rows {
+			if err := batcher.Add(row); err != nil {
+				return err
+			}
+		}
+	}
+
+	return batcher.Flush()
+}
+
+// parquetColumns lists the leaf columns of the parquet schema and the top level column names
+func parquetColumns(pr *reader.ParquetReader) (columns []parquetColumn, headers []string, err error) {
+	sh := pr.SchemaHandler
+	seen := map[string]bool{}
+	for _, inPath := range sh.ValueColumns {
+		idx, ok := sh.MapIndex[inPath]
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown parquet column %v", inPath)
+		}
+		inParts := common.StrToPath(inPath)
+		elements := make([]*parquet.SchemaElement, len(inParts))
+		for i := range inParts {
+			elements[i] = sh.SchemaElements[sh.MapIndex[common.PathToStr(inParts[:i+1])]]
+		}
+
+		// use the external names, skipping the root and the list wrappers
+		path := []string{}
+		for i := 1; i < len(inParts); i++ {
+			if isParquetListWrapper(elements[i-1], elements[i]) {
+				continue
+			}
+			if i > 1 && isParquetListWrapper(elements[i-2], elements[i-1]) {
+				continue
+			}
+			path = append(path, sh.GetExName(int(sh.MapIndex[common.PathToStr(inParts[:i+1])])))
+		}
+
+		maxRL, err := sh.MaxRepetitionLevel(inParts)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// only lists of primitive values are supported
+		leaf := len(elements) - 1
+		isPrimitiveList := elements[leaf].GetRepetitionType() == parquet.FieldRepetitionType_REPEATED ||
+			(leaf > 1 && isParquetListWrapper(elements[leaf-2], elements[leaf-1]))
+		if maxRL > 1 || (maxRL == 1 && !isPrimitiveList) {
+			return nil, nil, fmt.Errorf("parquet column %v is a nested repeated column, which is not supported", strings.Join(path, "."))
+		}
+
+		var defLevels []int32
+		if maxRL == 0 {
+			defLevels = make([]int32, len(path))
+			dl := int32(0)
+			for i := 1; i < len(elements); i++ {
+				if elements[i].GetRepetitionType() != parquet.FieldRepetitionType_REQUIRED {
+					dl++
+				}
+				defLevels[i-1] = dl
+			}
+		}
+
+		columns = append(columns, parquetColumn{
+			inPath:    inPath,
+			path:      path,
+			element:   sh.SchemaElements[idx],
+			repeated:  maxRL == 1,
+			defLevels: defLevels,
+		})
+		if !seen[path[0]] {
+			seen[path[0]] = true
+			headers = append(headers, path[0])
+		}
+	}
+	return columns, headers, nil
+}
+
+// isParquetListWrapper tells whether child is the repeated group of a LIST annotated parent
+func isParquetListWrapper(parent *parquet.SchemaElement, child *parquet.SchemaElement) bool {
+	isList := (parent.ConvertedType != nil && *parent.ConvertedType == parquet.ConvertedType_LIST) ||
+		(parent.LogicalType != nil && parent.LogicalType.LIST != nil)
+	return isList && child.GetNumChildren() > 0 && child.GetRepetitionType() == parquet.FieldRepetitionType_REPEATED
+}
+
+// readParquetColumn reads the values of the column for each row
+func readParquetColumn(pr *reader.ParquetReader, col parquetColumn, rows []map[string]interface{}) (err error) {
+	values, rls, dls, err := pr.ReadColumnByPath(col.inPath, int64(len(rows)))
+	if err != nil {
+		return err
+	}
+	maxDL, err := pr.SchemaHandler.MaxDefinitionLevel(common.StrToPath(col.inPath))
+	if err != nil {
+		return err
+	}
+
+	if !col.repeated {
+		for i := 0; i < len(rows) && i < len(values); i++ {
+			if dls[i] < maxDL {
+				setParquetValue(rows[i], col.nullPath(dls[i]), nil, false)
+				continue
+			}
+			setParquetValue(rows[i], col.path, parquetValue(col.element, values[i]), false)
+		}
+		return nil
+	}
+
+	// repeated values start a new row when the repetition level is 0
+	row := -1
+	for i, v := range values {
+		if rls[i] == 0 {
+			row++
+			if row >= len(rows) {
+				break
+			}
+			setParquetValue(rows[row], col.path, []interface{}{}, false)
+		}
+		if dls[i] < maxDL {
+			continue
+		}
+		setParquetValue(rows[row], col.path, parquetValue(col.element, v), true)
+	}
+	return nil
+}
+
+// nullPath is the path of the outermost null element of the column for the
+// definition level, so a null group is null rather than an object of nulls
+func (col parquetColumn) nullPath(dl int32) []string {
+	for i, level := range col.defLevels {
+		if level > dl {
+			return col.path[:i+1]
+		}
+	}
+	return col.path
+}
+
+// setParquetValue sets the value at the path of the row, creating the nested
+// objects on the way, appending it into an array when required
+func setParquetValue(row map[string]interface{}, path []string, value interface{}, appendValue bool) {
+	m := row
+	for _, key := range path[:len(path)-1] {
+		child, ok := m[key].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			m[key] = child
+		}
+		m = child
+	}
+
+	key := path[len(path)-1]
+	if appendValue {
+		arr, _ := m[key].([]interface{})
+		m[key] = append(arr, value)
+		return
+	}
+	m[key] = value
+}
+
+// parquetValue converts a parquet value into the JSON value validated by the
+// schema: integers stay integers, decimals become exact numbers and dates,
+// times and timestamps become RFC 3339 strings
+func parquetValue(el *parquet.SchemaElement, v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	lt := el.LogicalType
+	ct := el.ConvertedType
+
+	// decimals
+	if (lt != nil && lt.DECIMAL != nil) || (ct != nil && *ct == parquet.ConvertedType_DECIMAL) {
+		scale := int(el.GetScale())
+		if lt != nil && lt.DECIMAL != nil {
+			scale = int(lt.DECIMAL.Scale)
+		}
+		switch val := v.(type) {
+		case int32:
+			return parquetDecimal(big.NewInt(int64(val)), scale)
+		case int64:
+			return parquetDecimal(big.NewInt(val), scale)
+		case string:
+			// big-endian two's complement
+			n := new(big.Int).SetBytes([]byte(val))
+			if len(val) > 0 && val[0]&0x80 != 0 {
+				n.Sub(n, new(big.Int).Lsh(big.NewInt(1), uint(len(val)*8)))
+			}
+			return parquetDecimal(n, scale)
+		}
+	}
+
+	// dates are days since the unix epoch
+	if (lt != nil && lt.DATE != nil) || (ct != nil && *ct == parquet.ConvertedType_DATE) {
+		if days, ok := v.(int32); ok {
+			return time.Unix(int64(days)*24*60*60, 0).UTC().Format("2006-01-02")
+		}
+	}
+
+	// timestamps
+	if ts, ok := parquetTimestamp(el, v); ok {
+		return ts.Format(time.RFC3339Nano)
+	}
+
+	// times of the day
+	if d, ok := parquetTimeOfDay(el, v); ok {
+		return time.Unix(0, 0).UTC().Add(d).Format("15:04:05.999999999")
+	}
+
+	switch val := v.(type) {
+	case int32:
+		return int64(val)
+	case float32:
+		return float64(val)
+	}
+	return v
+}
+
+// parquetDecimal formats the unscaled value of a decimal as an exact number
+func parquetDecimal(unscaled *big.Int, scale int) json.Number {
+	s := new(big.Int).Abs(unscaled).String()
+	if scale > 0 {
+		if len(s) <= scale {
+			s = strings.Repeat("0", scale-len(s)+1) + s
+		}
+		s = s[:len(s)-scale] + "." + s[len(s)-scale:]
+	}
+	if unscaled.Sign() < 0 {
+		s = "-" + s
+	}
+	return json.Number(s)
+}
+
+func parquetTimestamp(el *parquet.SchemaElement, v interface{}) (ts time.Time, ok bool) {
+	if el.GetType() == parquet.Type_INT96 {
+		if s, ok := v.(string); ok {
+			return types.INT96ToTime(s).UTC(), true
+		}
+		return ts, false
+	}
+
+	val, ok := v.(int64)
+	if !ok {
+		return ts, false
+	}
+	lt := el.LogicalType
+	ct := el.ConvertedType
+	switch {
+	case lt != nil && lt.TIMESTAMP != nil && lt.TIMESTAMP.Unit != nil:
+		unit := lt.TIMESTAMP.Unit
+		switch {
+		case unit.MILLIS != nil:
+			return time.Unix(0, val*int64(time.Millisecond)).UTC(), true
+		case unit.MICROS != nil:
+			return time.Unix(0, val*int64(time.Microsecond)).UTC(), true
+		case unit.NANOS != nil:
+			return time.Unix(0, val).UTC(), true
+		}
+	case ct != nil && *ct == parquet.ConvertedType_TIMESTAMP_MILLIS:
+		return time.Unix(0, val*int64(time.Millisecond)).UTC(), true
+	case ct != nil && *ct == parquet.ConvertedType_TIMESTAMP_MICROS:
+		return time.Unix(0, val*int64(time.Microsecond)).UTC(), true
+	}
+	return ts, false
+}
+
+func parquetTimeOfDay(el *parquet.SchemaElement, v interface{}) (d time.Duration, ok bool) {
+	var val int64
+	switch n := v.(type) {
+	case int32:
+		val = int64(n)
+	case int64:
+		val = n
+	default:
+		return 0, false
+	}
+
+	lt := el.LogicalType
+	ct := el.ConvertedType
+	switch {
+	case lt != nil && lt.TIME != nil && lt.TIME.Unit != nil:
+		unit := lt.TIME.Unit
+		switch {
+		case unit.MILLIS != nil:
+			return time.Duration(val) * time.Millisecond, true
+		case unit.MICROS != nil:
+			return time.Duration(val) * time.Microsecond, true
+		case unit.NANOS != nil:
+			return time.Duration(val), true
+		}
+	case ct != nil && *ct == parquet.ConvertedType_TIME_MILLIS:
+		return time.Duration(val) * time.Millisecond, true
+	case ct != nil && *ct == parquet.ConvertedType_TIME_MICROS:
+		return time.Duration(val) * time.Microsecond, true
+	}
+	return 0, false
+}
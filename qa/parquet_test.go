@@ -0,0 +1,211 @@
+package qa
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DataHenHQ/datahen/records"
+	"github.com/xitongsys/parquet-go/parquet"
+)
+
+// readTestRecords reads the headers and the records of the file with the processor
+func readTestRecords(t *testing.T, process processFileStreamFn, filename string) (headers [][]string, recs []map[string]interface{}) {
+	t.Helper()
+	validateFn := func(batch []records.RecordGetSetterWithError) error {
+		for _, rec := range batch {
+			recs = append(recs, rec.(*mapRecord).data)
+		}
+		return nil
+	}
+	validateHeaders := func(h []string) (stop bool, err error) {
+		headers = append(headers, h)
+		return false, nil
+	}
+	if err := process(filename, 2, nil, validateFn, validateHeaders); err != nil {
+		t.Fatal(err)
+	}
+	return headers, recs
+}
+
+func TestProcessParquetFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		rows    []string
+		headers []string
+		want    []map[string]interface{}
+	}{
+		{
+			name: "integers and floats",
+			schema: `{"Tag": "name=root", "Fields": [
+				{"Tag": "name=small, type=INT32"},
+				{"Tag": "name=big, type=INT64, repetitiontype=OPTIONAL"},
+				{"Tag": "name=ratio, type=FLOAT"},
+				{"Tag": "name=ok, type=BOOLEAN"},
+				{"Tag": "name=name, inname=Name, type=BYTE_ARRAY, convertedtype=UTF8"}
+			]}`,
+			rows: []string{
+				`{"small": 1, "big": 9007199254740993, "ratio": 0.5, "ok": true, "name": "a"}`,
+				`{"small": -2, "ratio": 1, "ok": false, "name": ""}`,
+			},
+			headers: []string{"small", "big", "ratio", "ok", "name"},
+			want: []map[string]interface{}{
+				{"small": int64(1), "big": int64(9007199254740993), "ratio": 0.5, "ok": true, "name": "a"},
+				{"small": int64(-2), "big": nil, "ratio": 1.0, "ok": false, "name": ""},
+			},
+		},
+		{
+			name: "decimals",
+			schema: `{"Tag": "name=root", "Fields": [
+				{"Tag": "name=price, type=INT64, convertedtype=DECIMAL, scale=2, precision=10"},
+				{"Tag": "name=rate, type=INT32, convertedtype=DECIMAL, scale=3, precision=5, repetitiontype=OPTIONAL"}
+			]}`,
+			rows: []string{
+				`{"price": 12.34, "rate": -0.005}`,
+				`{"price": -1000.05}`,
+			},
+			want: []map[string]interface{}{
+				{"price": json.Number("12.34"), "rate": json.Number("-0.005")},
+				{"price": json.Number("-1000.05"), "rate": nil},
+			},
+		},
+		{
+			name: "dates and times",
+			schema: `{"Tag": "name=root", "Fields": [
+				{"Tag": "name=day, type=INT32, convertedtype=DATE"},
+				{"Tag": "name=millis, type=INT64, convertedtype=TIMESTAMP_MILLIS"},
+				{"Tag": "name=micros, type=INT64, logicaltype=TIMESTAMP, logicaltype.isadjustedtoutc=true, logicaltype.unit=MICROS"},
+				{"Tag": "name=nanos, type=INT64, logicaltype=TIMESTAMP, logicaltype.isadjustedtoutc=true, logicaltype.unit=NANOS"},
+				{"Tag": "name=at, type=INT32, convertedtype=TIME_MILLIS"}
+			]}`,
+			rows: []string{
+				`{"day": 19000, "millis": 1641600000123, "micros": 1641600000000001, "nanos": 1641600000000000001, "at": 45296789}`,
+			},
+			want: []map[string]interface{}{{
+				"day":    "2022-01-08",
+				"millis": "2022-01-08T00:00:00.123Z",
+				"micros": "2022-01-08T00:00:00.000001Z",
+				"nanos":  "2022-01-08T00:00:00.000000001Z",
+				"at":     "12:34:56.789",
+			}},
+		},
+		{
+			name: "repeated columns",
+			schema: `{"Tag": "name=root", "Fields": [
+				{"Tag": "name=id, type=INT64"},
+				{"Tag": "name=tags, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=REPEATED"},
+				{"Tag": "name=scores, type=LIST, repetitiontype=OPTIONAL", "Fields": [
+					{"Tag": "name=element, type=INT32"}
+				]}
+			]}`,
+			rows: []string{
+				`{"id": 1, "tags": ["a", "b"], "scores": [1, 2, 3]}`,
+				`{"id": 2, "tags": [], "scores": []}`,
+				`{"id": 3, "tags": ["c"]}`,
+			},
+			headers: []string{"id", "tags", "scores"},
+			want: []map[string]interface{}{
+				{"id": int64(1), "tags": []interface{}{"a", "b"}, "scores": []interface{}{int64(1), int64(2), int64(3)}},
+				{"id": int64(2), "tags": []interface{}{}, "scores": []interface{}{}},
+				{"id": int64(3), "tags": []interface{}{"c"}, "scores": []interface{}{}},
+			},
+		},
+		{
+			name: "nested groups",
+			schema: `{"Tag": "name=root", "Fields": [
+				{"Tag": "name=id, type=INT64"},
+				{"Tag": "name=address, repetitiontype=OPTIONAL", "Fields": [
+					{"Tag": "name=city, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=OPTIONAL"},
+					{"Tag": "name=geo, repetitiontype=OPTIONAL", "Fields": [
+						{"Tag": "name=lat, type=DOUBLE"}
+					]}
+				]}
+			]}`,
+			rows: []string{
+				`{"id": 1, "address": {"city": "Paris", "geo": {"lat": 48.85}}}`,
+				`{"id": 2, "address": {"city": "Lyon"}}`,
+				`{"id": 3, "address": {}}`,
+				`{"id": 4}`,
+			},
+			headers: []string{"id", "address"},
+			want: []map[string]interface{}{
+				{"id": int64(1), "address": map[string]interface{}{"city": "Paris", "geo": map[string]interface{}{"lat": 48.85}}},
+				{"id": int64(2), "address": map[string]interface{}{"city": "Lyon", "geo": nil}},
+				{"id": int64(3), "address": map[string]interface{}{"city": nil, "geo": nil}},
+				{"id": int64(4), "address": nil},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := filepath.Join(t.TempDir(), "data.parquet")
+			writeTestFiles(t, filepath.Dir(filename), map[string]string{
+				"data.parquet": parquetString(t, tt.schema, tt.rows...),
+			})
+
+			headers, got := readTestRecords(t, processParquetFile, filename)
+			if len(headers) != 1 {
+				t.Fatalf("got headers %v, want them once", headers)
+			}
+			if tt.headers != nil && !reflect.DeepEqual(headers[0], tt.headers) {
+				t.Errorf("got headers %v, want %v", headers[0], tt.headers)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got records %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessParquetFileNestedLists(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "data.parquet")
+	writeTestFiles(t, filepath.Dir(filename), map[string]string{
+		"data.parquet": parquetString(t, `{"Tag": "name=root", "Fields": [
+			{"Tag": "name=items, repetitiontype=REPEATED", "Fields": [
+				{"Tag": "name=tags, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=REPEATED"}
+			]}
+		]}`, `{"items": [{"tags": ["a"]}]}`),
+	})
+
+	err := processParquetFile(filename, 10, nil, func([]records.RecordGetSetterWithError) error { return nil }, nil)
+	if err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("got error %v, want nested repeated columns not supported", err)
+	}
+}
+
+func TestParquetDecimalValue(t *testing.T) {
+	decimal := func(scale int32) *parquet.SchemaElement {
+		ct := parquet.ConvertedType_DECIMAL
+		return &parquet.SchemaElement{ConvertedType: &ct, Scale: &scale}
+	}
+	logical := &parquet.SchemaElement{LogicalType: &parquet.LogicalType{DECIMAL: &parquet.DecimalType{Scale: 2, Precision: 9}}}
+
+	tests := []struct {
+		name string
+		el   *parquet.SchemaElement
+		v    interface{}
+		want json.Number
+	}{
+		{"int32", decimal(2), int32(1234), "12.34"},
+		{"int64 zeros kept", decimal(2), int64(123400), "1234.00"},
+		{"padded fraction", decimal(3), int64(5), "0.005"},
+		{"negative fraction", decimal(3), int32(-5), "-0.005"},
+		{"negative", decimal(2), int64(-100005), "-1000.05"},
+		{"no scale", decimal(0), int64(42), "42"},
+		{"byte array", decimal(1), "\x01\x00", "25.6"},
+		{"negative byte array", decimal(1), "\xff\x85", "-12.3"},
+		{"logical type", logical, int64(-1), "-0.01"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parquetValue(tt.el, tt.v); got != tt.want {
+				t.Errorf("got %#v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,425 @@
+package qa
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"math"
+	"math/bits"
+	"path/filepath"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/DataHenHQ/datahen/records"
+)
+
+// DefaultProfileTopN is the number of most frequent values profiled per field
+const DefaultProfileTopN = 10
+
+// FileProfile keeps the statistics of the fields of the records of a file.
+type FileProfile struct {
+	RecordCount uint64                   `json:"record_count"`
+	Fields      map[string]*FieldProfile `json:"fields"`
+}
+
+// FieldProfile keeps the statistics of a top level field.
+type FieldProfile struct {
+	// Count is the number of records having the field, null or not.
+	Count      uint64 `json:"count"`
+	NullCount  uint64 `json:"null_count"`
+	EmptyCount uint64 `json:"empty_count"`
+	// FillRate is the percentage of records with a non null, non empty value.
+	FillRate float64 `json:"fill_rate"`
+	// DistinctEstimate is the estimated number of distinct values, within about 2%.
+	DistinctEstimate uint64 `json:"distinct_estimate"`
+	// Types counts the values by JSON type.
+	Types  map[string]uint64 `json:"types"`
+	Number *NumberProfile    `json:"number,omitempty"`
+	Length *LengthProfile    `json:"length,omitempty"`
+	// Top are the most frequent values. Their counts are exact unless the field
+	// has many distinct values, and never overestimated.
+	Top []ValueCount `json:"top,omitempty"`
+}
+
+// NumberProfile keeps the statistics of the numeric values of a field.
+type NumberProfile struct {
+	Count uint64  `json:"count"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Mean  float64 `json:"mean"`
+}
+
+// LengthProfile keeps the distribution of the lengths, in characters, of the
+// string values of a field.
+type LengthProfile struct {
+	Min          int            `json:"min"`
+	Max          int            `json:"max"`
+	Mean         float64        `json:"mean"`
+	Distribution []LengthBucket `json:"distribution"`
+}
+
+// LengthBucket counts the string values whose length is within its range.
+type LengthBucket struct {
+	Range string `json:"range"`
+	Count uint64 `json:"count"`
+}
+
+// ValueCount is a frequent value of a field.
+type ValueCount struct {
+	Value interface{} `json:"value"`
+	Count uint64      `json:"count"`
+}
+
+// lengthBucketLimits are the upper limits of the length buckets, longer
+// strings fall into a last bucket
+var lengthBucketLimits = []int{0, 8, 16, 32, 64, 128, 256, 512, 1024}
+
+// recordProfiler profiles the records of a file as they are validated
+type recordProfiler struct {
+	topN        int
+	recordCount uint64
+	fields      map[string]*fieldProfiler
+}
+
+// fieldProfiler accumulates the statistics of a field
+type fieldProfiler struct {
+	p          *FieldProfile
+	distinct   *hyperLogLog
+	top        *topValues
+	numSum     float64
+	number     NumberProfile
+	lenSum     uint64
+	length     LengthProfile
+	lenBuckets []uint64
+	strings    uint64
+}
+
+func newRecordProfiler(topN int) *recordProfiler {
+	return &recordProfiler{topN: topN, fields: map[string]*fieldProfiler{}}
+}
+
+// add profiles the fields of the records
+func (rp *recordProfiler) add(recs []records.RecordGetSetterWithError) (err error) {
+	for _, rec := range recs {
+		rp.recordCount++
+		for _, k := range rec.Keys() {
+			if k == "_collection" {
+				continue
+			}
+			value, _ := rec.Get(k)
+
+			fp := rp.fields[k]
+			if fp == nil {
+				fp = &fieldProfiler{
+					p:          &FieldProfile{Types: map[string]uint64{}},
+					distinct:   newHyperLogLog(),
+					top:        newTopValues(rp.topN * topValuesPerN),
+					lenBuckets: make([]uint64, len(lengthBucketLimits)+1),
+				}
+				rp.fields[k] = fp
+			}
+			if err := fp.add(value); err != nil {
+				return fmt.Errorf("cannot profile field %v: %v", k, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (fp *fieldProfiler) add(value interface{}) (err error) {
+	fp.p.Count++
+	if value == nil {
+		fp.p.NullCount++
+		fp.p.Types["null"]++
+		return nil
+	}
+
+	switch val := value.(type) {
+	case string:
+		fp.p.Types["string"]++
+		if val == "" {
+			fp.p.EmptyCount++
+		}
+		fp.addLength(utf8.RuneCountInString(val))
+	case bool:
+		fp.p.Types["boolean"]++
+	case map[string]interface{}:
+		fp.p.Types["object"]++
+	case []interface{}:
+		fp.p.Types["array"]++
+	default:
+		if n, ok := toFloat(value); ok {
+			fp.p.Types["number"]++
+			fp.addNumber(n)
+		} else {
+			fp.p.Types[fmt.Sprintf("%T", value)]++
+		}
+	}
+
+	// values are told apart by their JSON encoding
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	fp.distinct.add(data)
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+	default:
+		fp.top.add(string(data), value)
+	}
+	return nil
+}
+
+func (fp *fieldProfiler) addNumber(n float64) {
+	if fp.number.Count == 0 || n < fp.number.Min {
+		fp.number.Min = n
+	}
+	if fp.number.Count == 0 || n > fp.number.Max {
+		fp.number.Max = n
+	}
+	fp.number.Count++
+	fp.numSum += n
+}
+
+func (fp *fieldProfiler) addLength(l int) {
+	if fp.strings == 0 || l < fp.length.Min {
+		fp.length.Min = l
+	}
+	if fp.strings == 0 || l > fp.length.Max {
+		fp.length.Max = l
+	}
+	fp.strings++
+	fp.lenSum += uint64(l)
+
+	i := sort.SearchInts(lengthBucketLimits, l)
+	fp.lenBuckets[i]++
+}
+
+// toFloat converts the numeric values read by the record processors
+func toFloat(value interface{}) (n float64, ok bool) {
+	switch val := value.(type) {
+	case float64:
+		return val, true
+	case float32:
+		return float64(val), true
+	case int:
+		return float64(val), true
+	case int32:
+		return float64(val), true
+	case int64:
+		return float64(val), true
+	case uint64:
+		return float64(val), true
+	case json.Number:
+		n, err := val.Float64()
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// profile returns the statistics of the fields
+func (rp *recordProfiler) profile() *FileProfile {
+	fileProfile := &FileProfile{RecordCount: rp.recordCount, Fields: make(map[string]*FieldProfile, len(rp.fields))}
+	for k, fp := range rp.fields {
+		p := fp.p
+		if rp.recordCount > 0 {
+			filled := p.Count - p.NullCount - p.EmptyCount
+			p.FillRate = math.Round(float64(filled)*10000/float64(rp.recordCount)) / 100
+		}
+		p.DistinctEstimate = fp.distinct.estimate()
+
+		if fp.number.Count > 0 {
+			number := fp.number
+			number.Mean = fp.numSum / float64(number.Count)
+			p.Number = &number
+		}
+		if fp.strings > 0 {
+			length := fp.length
+			length.Mean = float64(fp.lenSum) / float64(fp.strings)
+			for i, count := range fp.lenBuckets {
+				if count > 0 {
+					length.Distribution = append(length.Distribution, LengthBucket{Range: lengthBucketRange(i), Count: count})
+				}
+			}
+			p.Length = &length
+		}
+		p.Top = fp.top.most(rp.topN)
+
+		fileProfile.Fields[k] = p
+	}
+	return fileProfile
+}
+
+// lengthBucketRange names the range of the length bucket
+func lengthBucketRange(i int) string {
+	switch {
+	case i == 0:
+		return "0"
+	case i == len(lengthBucketLimits):
+		return fmt.Sprintf("%d+", lengthBucketLimits[i-1]+1)
+	}
+	return fmt.Sprintf("%d-%d", lengthBucketLimits[i-1]+1, lengthBucketLimits[i])
+}
+
+// writeProfile saves the profile of the report key under the profile folder
+func writeProfile(outDir string, reportKey string, profile *FileProfile) (err error) {
+	profileFile, err := reportFilePath(filepath.Join(outDir, "profile"), reportKey)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(profile, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(profileFile, data, 0644)
+}
+
+// hllPrecision is the number of hash bits indexing the registers of the
+// distinct count estimates, 4096 registers for a 1.6% standard error
+const hllPrecision = 12
+
+// hyperLogLog estimates the number of distinct values added
+type hyperLogLog struct {
+	registers []uint8
+}
+
+func newHyperLogLog() *hyperLogLog {
+	return &hyperLogLog{registers: make([]uint8, 1<<hllPrecision)}
+}
+
+func (h *hyperLogLog) add(data []byte) {
+	hf := fnv.New64a()
+	hf.Write(data)
+	x := mix64(hf.Sum64())
+
+	idx := x >> (64 - hllPrecision)
+	rho := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1))) + 1
+	if rho > h.registers[idx] {
+		h.registers[idx] = rho
+	}
+}
+
+func (h *hyperLogLog) estimate() uint64 {
+	m := float64(len(h.registers))
+	sum, zeros := 0.0, 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	e := 0.7213 / (1 + 1.079/m) * m * m / sum
+
+	// small cardinalities are better estimated by linear counting
+	if e <= 2.5*m && zeros > 0 {
+		e = m * math.Log(m/float64(zeros))
+	}
+	return uint64(math.Round(e))
+}
+
+// mix64 spreads the bits of the FNV hash, whose high bits are weak
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// topValuesPerN is the number of value counters kept per most frequent
+// value profiled, so values more frequent than 1/(topN*topValuesPerN) of the
+// records are always found
+const topValuesPerN = 100
+
+// topValues counts the most frequent values with a bounded number of
+// counters, evicting the least frequent value for a new one (Space-Saving)
+type topValues struct {
+	capacity int
+	counters map[string]*valueCounter
+	heap     valueCounterHeap
+}
+
+type valueCounter struct {
+	key   string
+	value interface{}
+	count uint64
+	// overcount is the count of the evicted value the counter took over
+	overcount uint64
+	index     int
+}
+
+func newTopValues(capacity int) *topValues {
+	return &topValues{capacity: capacity, counters: map[string]*valueCounter{}}
+}
+
+func (t *topValues) add(key string, value interface{}) {
+	if t.capacity < 1 {
+		return
+	}
+	if c, ok := t.counters[key]; ok {
+		c.count++
+		heap.Fix(&t.heap, c.index)
+		return
+	}
+	if len(t.heap) < t.capacity {
+		c := &valueCounter{key: key, value: value, count: 1}
+		t.counters[key] = c
+		heap.Push(&t.heap, c)
+		return
+	}
+
+	// the new value takes over the least frequent counter
+	c := t.heap[0]
+	delete(t.counters, c.key)
+	c.key, c.value = key, value
+	c.overcount = c.count
+	c.count++
+	t.counters[key] = c
+	heap.Fix(&t.heap, 0)
+}
+
+// most returns the n most frequent values with their guaranteed counts
+func (t *topValues) most(n int) (top []ValueCount) {
+	counters := append(valueCounterHeap{}, t.heap...)
+	sort.Slice(counters, func(i, j int) bool {
+		ci, cj := counters[i].count-counters[i].overcount, counters[j].count-counters[j].overcount
+		if ci != cj {
+			return ci > cj
+		}
+		return counters[i].key < counters[j].key
+	})
+	if len(counters) > n {
+		counters = counters[:n]
+	}
+	for _, c := range counters {
+		top = append(top, ValueCount{Value: c.value, Count: c.count - c.overcount})
+	}
+	return top
+}
+
+// valueCounterHeap is a min-heap of the value counters by count
+type valueCounterHeap []*valueCounter
+
+func (h valueCounterHeap) Len() int           { return len(h) }
+func (h valueCounterHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h valueCounterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *valueCounterHeap) Push(x interface{}) {
+	c := x.(*valueCounter)
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *valueCounterHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
@@ -0,0 +1,160 @@
+package qa
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/DataHenHQ/datahen/records"
+)
+
+func TestHyperLogLogEstimate(t *testing.T) {
+	tests := []struct {
+		distinct  int
+		tolerance float64
+	}{
+		{0, 0},
+		{1, 0},
+		{100, 0.02},
+		{1000, 0.03},
+		{10000, 0.05},
+		{100000, 0.05},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.distinct), func(t *testing.T) {
+			h := newHyperLogLog()
+			// repeated values don't count
+			for r := 0; r < 2; r++ {
+				for i := 0; i < tt.distinct; i++ {
+					h.add([]byte(fmt.Sprintf("value-%d", i)))
+				}
+			}
+
+			got := float64(h.estimate())
+			if diff := math.Abs(got - float64(tt.distinct)); diff > tt.tolerance*float64(tt.distinct) {
+				t.Errorf("got estimate %v of %v distinct values, want within %v%%", got, tt.distinct, tt.tolerance*100)
+			}
+		})
+	}
+}
+
+func TestTopValuesExact(t *testing.T) {
+	tv := newTopValues(10)
+	counts := map[string]int{"a": 5, "b": 3, "c": 3, "d": 1}
+	for key, n := range counts {
+		for i := 0; i < n; i++ {
+			tv.add(key, key)
+		}
+	}
+
+	want := []ValueCount{{"a", 5}, {"b", 3}, {"c", 3}}
+	if got := tv.most(3); !reflect.DeepEqual(got, want) {
+		t.Errorf("got top values %v, want %v", got, want)
+	}
+	if got := tv.most(10); len(got) != 4 {
+		t.Errorf("got %v top values, want the 4 values", len(got))
+	}
+
+	none := newTopValues(0)
+	none.add("a", "a")
+	if got := none.most(1); len(got) != 0 {
+		t.Errorf("got top values %v without counters", got)
+	}
+}
+
+func TestTopValuesSpaceSaving(t *testing.T) {
+	capacity := 50
+	tv := newTopValues(capacity)
+
+	// the frequent values are interleaved with many more distinct rare values
+	// than there are counters
+	frequent := map[string]uint64{"x": 3000, "y": 2000, "z": 1000}
+	total := map[string]uint64{}
+	var n uint64
+	for i := 0; i < 3000; i++ {
+		for _, key := range []string{"x", "y", "z"} {
+			if uint64(i) < frequent[key] {
+				tv.add(key, key)
+				total[key]++
+				n++
+			}
+		}
+		for j := 0; j < 3; j++ {
+			rare := fmt.Sprintf("rare-%d", i*3+j)
+			tv.add(rare, rare)
+			total[rare]++
+			n++
+		}
+	}
+
+	top := tv.most(3)
+	if len(top) != 3 {
+		t.Fatalf("got top values %v, want 3", top)
+	}
+	for i, key := range []string{"x", "y", "z"} {
+		if top[i].Value != key {
+			t.Errorf("got top value %v at %v, want %v", top[i].Value, i, key)
+		}
+	}
+
+	// the guaranteed counts are never overestimated, and the frequent values
+	// are off by at most the stream length over the counters
+	maxError := n / uint64(capacity)
+	for _, vc := range tv.most(capacity) {
+		key := vc.Value.(string)
+		if vc.Count > total[key] {
+			t.Errorf("got count %v of %v, more than its %v records", vc.Count, key, total[key])
+		}
+		if want, ok := frequent[key]; ok && vc.Count+maxError < want {
+			t.Errorf("got count %v of %v, want at least %v", vc.Count, key, want-maxError)
+		}
+	}
+}
+
+func TestRecordProfiler(t *testing.T) {
+	rp := newRecordProfiler(2)
+	var recs []records.RecordGetSetterWithError
+	for i := 0; i < 1000; i++ {
+		data := map[string]interface{}{
+			"id":    json.Number(fmt.Sprint(i)),
+			"color": []string{"red", "red", "blue", ""}[i%4],
+		}
+		if i%10 == 0 {
+			data["note"] = nil
+		}
+		recs = append(recs, newMapRecord(data))
+	}
+	if err := rp.add(recs); err != nil {
+		t.Fatal(err)
+	}
+
+	profile := rp.profile()
+	if profile.RecordCount != 1000 {
+		t.Errorf("got record count %v, want 1000", profile.RecordCount)
+	}
+
+	id := profile.Fields["id"]
+	if d := math.Abs(float64(id.DistinctEstimate) - 1000); d > 30 {
+		t.Errorf("got distinct estimate %v of id, want about 1000", id.DistinctEstimate)
+	}
+	if id.Number == nil || id.Number.Min != 0 || id.Number.Max != 999 || id.Number.Mean != 499.5 {
+		t.Errorf("got id number profile %+v", id.Number)
+	}
+
+	color := profile.Fields["color"]
+	if color.DistinctEstimate != 3 || color.EmptyCount != 250 || color.FillRate != 75 {
+		t.Errorf("got color distinct %v, empty %v, fill rate %v", color.DistinctEstimate, color.EmptyCount, color.FillRate)
+	}
+	wantTop := []ValueCount{{"red", 500}, {"", 250}}
+	if !reflect.DeepEqual(color.Top, wantTop) {
+		t.Errorf("got color top values %v, want %v", color.Top, wantTop)
+	}
+
+	note := profile.Fields["note"]
+	if note.Count != 100 || note.NullCount != 100 || note.FillRate != 0 {
+		t.Errorf("got note count %v, null count %v, fill rate %v", note.Count, note.NullCount, note.FillRate)
+	}
+}
@@ -0,0 +1,104 @@
+package qa
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/DataHenHQ/datahen/records"
+)
+
+// processReaderFn processes the records of an input stream, the same way
+// processFileStreamFn does for files
+type processReaderFn func(r io.Reader, batchSize int, configReaderFn records.ConfigReaderFn, validateFn records.ValidateFn, validatePreRecords records.ValidateHeadersFn) (err error)
+
+// readerProcessorFor returns the stream processor of the file name, by its
+// extension, reading CSV files with the dialect
+func readerProcessorFor(name string, co *csvOptions) (process processReaderFn, includeCollection bool, ok bool) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv", ".tsv":
+		return csvReaderProcessor(co), false, true
+	case ".json":
+		return processJSONReader, true, true
+	case ".njson", ".ndjson":
+		return processJSONReader, false, true
+	}
+	return nil, false, false
+}
+
+// spoolingProcessor copies the stream into a temp file named with the
+// extension of name, for the file processors that need to seek, such as the
+// parquet and Excel ones. The temp file is removed once processed.
+func spoolingProcessor(name string, process processFileStreamFn) processReaderFn {
+	return func(r io.Reader, batchSize int, configReaderFn records.ConfigReaderFn, validateFn records.ValidateFn, validatePreRecords records.ValidateHeadersFn) (err error) {
+		tmp, err := ioutil.TempFile("", "henqa-*"+filepath.Ext(name))
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+
+		_, err = io.Copy(tmp, r)
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("cannot spool %v: %v", name, err)
+		}
+		return process(tmp.Name(), batchSize, configReaderFn, validateFn, validatePreRecords)
+	}
+}
+
+// processJSONReader reads the records of a stream holding either a JSON array
+// of objects or one JSON object per line. configReaderFn configures the CSV
+// readers only and has no use for JSON.
+func processJSONReader(r io.Reader, batchSize int, configReaderFn records.ConfigReaderFn, validateFn records.ValidateFn, validatePreRecords records.ValidateHeadersFn) (err error) {
+	br := bufio.NewReader(r)
+	first, err := peekNonSpace(br)
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(br)
+	dec.UseNumber()
+	if first == '[' {
+		if _, err := dec.Token(); err != nil {
+			return err
+		}
+	}
+
+	batcher := newRecordBatcher(batchSize, validateFn)
+	for dec.More() {
+		data := map[string]interface{}{}
+		if err := dec.Decode(&data); err != nil {
+			return err
+		}
+		if err := batcher.Add(data); err != nil {
+			return err
+		}
+	}
+
+	return batcher.Flush()
+}
+
+// peekNonSpace returns the first byte of the stream that isn't whitespace or
+// part of a UTF-8 BOM, without consuming it
+func peekNonSpace(br *bufio.Reader) (b byte, err error) {
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if !unicode.IsSpace(rune(c)) && c != 0xEF && c != 0xBB && c != 0xBF {
+			return c, br.UnreadByte()
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package qa
+
+import (
+	"sort"
+
+	"github.com/DataHenHQ/datahen/records"
+)
+
+// defaultCollection is the collection of records without a _collection field
+const defaultCollection = "default"
+
+// mapRecord is a record read by henqa's own readers, backed by a map the same
+// way the records of datahen's JSON processors are.
+type mapRecord struct {
+	data       map[string]interface{}
+	collection string
+	errors     []records.SchemaError
+	// uncoerced are the fields that failed the coercion to their schema
+	// type, reported as coercion_failed rather than invalid_type
+	uncoerced map[string]bool
+}
+
+var _ records.RecordGetSetterWithError = (*mapRecord)(nil)
+
+// newMapRecord wraps the data into a record, using its _collection field as
+// the collection when present
+func newMapRecord(data map[string]interface{}) *mapRecord {
+	rec := &mapRecord{data: data, collection: defaultCollection}
+	if col, ok := data["_collection"].(string); ok && col != "" {
+		rec.collection = col
+	}
+	return rec
+}
+
+func (r *mapRecord) Get(key string) (value interface{}, ok bool) {
+	value, ok = r.data[key]
+	return value, ok
+}
+
+func (r *mapRecord) GetCollection() string {
+	return r.collection
+}
+
+func (r *mapRecord) Keys() []string {
+	keys := make([]string, 0, len(r.data))
+	for k := range r.data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (r *mapRecord) Set(key string, value interface{}) {
+	r.data[key] = value
+}
+
+func (r *mapRecord) SetCollection(collection string) {
+	r.collection = collection
+}
+
+func (r *mapRecord) GetErrors() []records.SchemaError {
+	if len(r.uncoerced) == 0 {
+		return r.errors
+	}
+
+	var errs []records.SchemaError
+	for _, e := range r.errors {
+		if e.ErrorType == "invalid_type" && r.uncoerced[e.Field] {
+			continue
+		}
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+func (r *mapRecord) AddErrors(errs ...records.SchemaError) {
+	r.errors = append(r.errors, errs...)
+}
+
+// addCoercionErrors adds the errors of the fields that failed the coercion
+func (r *mapRecord) addCoercionErrors(errs []records.SchemaError) {
+	if len(errs) == 0 {
+		return
+	}
+	if r.uncoerced == nil {
+		r.uncoerced = map[string]bool{}
+	}
+	for _, e := range errs {
+		r.uncoerced[e.Field] = true
+	}
+	r.AddErrors(errs...)
+}
+
+// recordBatcher groups the records of henqa's own readers into batches for
+// the validate function
+type recordBatcher struct {
+	batchSize  int
+	validateFn records.ValidateFn
+	recs       []records.RecordGetSetterWithError
+}
+
+func newRecordBatcher(batchSize int, validateFn records.ValidateFn) *recordBatcher {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	return &recordBatcher{
+		batchSize:  batchSize,
+		validateFn: validateFn,
+		recs:       make([]records.RecordGetSetterWithError, 0, batchSize),
+	}
+}
+
+// Add queues the record data and validates the batch once it is full
+func (b *recordBatcher) Add(data map[string]interface{}) (err error) {
+	return b.AddRecord(newMapRecord(data))
+}
+
+// AddRecord queues the record and validates the batch once it is full
+func (b *recordBatcher) AddRecord(rec *mapRecord) (err error) {
+	b.recs = append(b.recs, rec)
+	if len(b.recs) < b.batchSize {
+		return nil
+	}
+	return b.Flush()
+}
+
+// Flush validates the queued records
+func (b *recordBatcher) Flush() (err error) {
+	if len(b.recs) == 0 {
+		return nil
+	}
+	recs := b.recs
+	b.recs = make([]records.RecordGetSetterWithError, 0, b.batchSize)
+	return b.validateFn(recs)
+}
@@ -0,0 +1,407 @@
+package qa
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// schemaRegistry holds the local schemas that $ref can point to, by their
+// absolute path and by their $id
+type schemaRegistry struct {
+	byPath map[string]*registeredSchema
+	byID   map[string]*registeredSchema
+	dirs   []string
+	// fetch returns the cached copy of a remote schema, fetched through the
+	// schema cache, so the remote $ref are bundled as well
+	fetch func(rawURL string) (path string, err error)
+}
+
+// registeredSchema is a local schema, bundled into the referencing schemas
+// under definitions/<key>
+type registeredSchema struct {
+	path string
+	id   string
+	doc  interface{}
+	key  string
+	// url is the URL of the remote schema cached at path
+	url string
+}
+
+// newSchemaRegistry registers the schema files and the .json, .yaml and
+// .yml files of the schema directories
+func newSchemaRegistry(dirs []string, files []string) (reg *schemaRegistry, err error) {
+	reg = &schemaRegistry{byPath: map[string]*registeredSchema{}, byID: map[string]*registeredSchema{}}
+
+	for _, dir := range dirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		reg.dirs = append(reg.dirs, abs)
+
+		err = filepath.Walk(abs, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || !isSchemaFile(path) {
+				return nil
+			}
+			_, err = reg.register(path)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cannot read schema directory %v: %v", dir, err)
+		}
+	}
+
+	// unreadable schema files are reported when they are merged
+	for _, f := range files {
+		reg.register(f)
+	}
+	return reg, nil
+}
+
+// alias registers the cached copy of a remote schema under its URL, which is
+// also the base of its relative references when it has no $id
+func (reg *schemaRegistry) alias(rawURL string, path string) {
+	rs, err := reg.register(path)
+	if err != nil {
+		return
+	}
+	rs.url = rawURL
+	if rs.id == "" {
+		rs.id = rawURL
+	}
+	if _, ok := reg.byID[rawURL]; !ok {
+		reg.byID[rawURL] = rs
+	}
+}
+
+func isSchemaFile(path string) bool {
+	switch filepath.Ext(path) {
+	case ".json", ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// register reads the schema file, once
+func (reg *schemaRegistry) register(path string) (rs *registeredSchema, err error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if rs, ok := reg.byPath[abs]; ok {
+		return rs, nil
+	}
+
+	data, err := readSchemaFile(abs)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := decodeJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema file %v: %v", path, err)
+	}
+
+	rs = &registeredSchema{path: abs, doc: doc, id: schemaID(doc)}
+	reg.byPath[abs] = rs
+	if rs.id != "" {
+		reg.byID[rs.id] = rs
+	}
+	return rs, nil
+}
+
+// readSchemaFile reads a JSON or YAML schema file as JSON
+func readSchemaFile(path string) (data []byte, err error) {
+	data, err = ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		data, err = yaml.YAMLToJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("error converting YAML to JSON: %v", err)
+		}
+	}
+	return data, nil
+}
+
+func decodeJSON(data []byte) (doc interface{}, err error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	err = dec.Decode(&doc)
+	return doc, err
+}
+
+// schemaID returns the $id of the schema, without its empty fragment
+func schemaID(doc interface{}) string {
+	m, _ := doc.(map[string]interface{})
+	id, _ := m["$id"].(string)
+	if id == "" {
+		id, _ = m["id"].(string)
+	}
+	return strings.TrimSuffix(id, "#")
+}
+
+// lookup finds the schema of the $ref URI, without fragment, by $id resolved
+// against the base $id, then by path relative to the referencing schema and
+// to the schema directories. Remote URIs that are not registered are fetched.
+func (reg *schemaRegistry) lookup(uri string, baseID string, baseDir string) (rs *registeredSchema, err error) {
+	ref, err := url.Parse(uri)
+	if err != nil {
+		return nil, nil
+	}
+	absURI := resolveRefURI(uri, baseID)
+	if rs, ok := reg.byID[absURI]; ok {
+		return rs, nil
+	}
+	if ref.IsAbs() {
+		if isRemoteSchema(uri) {
+			return reg.fetchRemote(uri)
+		}
+		if ref.Scheme != "file" {
+			return nil, nil
+		}
+		uri = ref.Path
+	}
+	if rs, ok := reg.byID[uri]; ok {
+		return rs, nil
+	}
+
+	path := filepath.FromSlash(uri)
+	candidates := []string{path}
+	if !filepath.IsAbs(path) {
+		candidates = []string{filepath.Join(baseDir, path)}
+		for _, dir := range reg.dirs {
+			candidates = append(candidates, filepath.Join(dir, path))
+		}
+	}
+	for _, c := range candidates {
+		if rs, ok := reg.byPath[filepath.Clean(c)]; ok {
+			return rs, nil
+		}
+		if fileExists(c) && isSchemaFile(c) {
+			if rs, err := reg.register(c); err == nil {
+				return rs, nil
+			}
+		}
+	}
+
+	// relative references of remote schemas are remote as well
+	if isRemoteSchema(absURI) {
+		return reg.fetchRemote(absURI)
+	}
+	return nil, nil
+}
+
+// resolveRefURI resolves the $ref URI against the base $id, when absolute
+func resolveRefURI(uri string, baseID string) string {
+	ref, err := url.Parse(uri)
+	if err != nil || ref.IsAbs() {
+		return uri
+	}
+	base, err := url.Parse(baseID)
+	if err != nil || !base.IsAbs() {
+		return uri
+	}
+	return base.ResolveReference(ref).String()
+}
+
+// fetchRemote registers the cached copy of the remote schema under its URL
+func (reg *schemaRegistry) fetchRemote(rawURL string) (rs *registeredSchema, err error) {
+	if reg.fetch == nil {
+		return nil, nil
+	}
+	path, err := reg.fetch(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := reg.register(path); err != nil {
+		return nil, fmt.Errorf("invalid schema %v: %v", rawURL, err)
+	}
+	reg.alias(rawURL, path)
+	return reg.byID[rawURL], nil
+}
+
+// schemaBundler rewrites the $ref to local schemas of a schema into local
+// references to copies bundled under its definitions, so the schema is
+// self-contained
+type schemaBundler struct {
+	reg     *schemaRegistry
+	root    *registeredSchema
+	defs    map[string]interface{}
+	bundled map[*registeredSchema]bool
+	// err is the first error resolving a reference
+	err error
+}
+
+// bundleSchemaRefs bundles the local and remote schemas referenced by the
+// schema of the file. Unresolved local references are kept as they are,
+// remote ones that cannot be fetched fail.
+func bundleSchemaRefs(reg *schemaRegistry, schema []byte, path string) (bundled []byte, err error) {
+	doc, err := decodeJSON(schema)
+	if err != nil {
+		return nil, err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	root := &registeredSchema{path: abs, id: schemaID(doc), doc: doc}
+	if rs, ok := reg.byPath[abs]; ok && root.id == "" {
+		root.id = rs.id
+	}
+
+	b := &schemaBundler{
+		reg:     reg,
+		root:    root,
+		defs:    map[string]interface{}{},
+		bundled: map[*registeredSchema]bool{},
+	}
+	doc = b.rewrite(doc, b.root.id, filepath.Dir(abs), "")
+	if b.err != nil {
+		return nil, b.err
+	}
+	if len(b.defs) == 0 {
+		return schema, nil
+	}
+
+	m, ok := doc.(map[string]interface{})
+	if !ok {
+		return schema, nil
+	}
+	defs, _ := m["definitions"].(map[string]interface{})
+	if defs == nil {
+		defs = map[string]interface{}{}
+	}
+	for k, def := range b.defs {
+		defs[k] = def
+	}
+	m["definitions"] = defs
+
+	return json.Marshal(m)
+}
+
+// rewrite returns a copy of the node with its references rewritten. Local
+// references of bundled schemas are prefixed with their location.
+func (b *schemaBundler) rewrite(node interface{}, baseID string, baseDir string, prefix string) interface{} {
+	switch val := node.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(val))
+		for k, v := range val {
+			if ref, ok := v.(string); ok && k == "$ref" {
+				m[k] = b.rewriteRef(ref, baseID, baseDir, prefix)
+				continue
+			}
+			m[k] = b.rewrite(v, baseID, baseDir, prefix)
+		}
+		return m
+	case []interface{}:
+		arr := make([]interface{}, len(val))
+		for i, v := range val {
+			arr[i] = b.rewrite(v, baseID, baseDir, prefix)
+		}
+		return arr
+	}
+	return node
+}
+
+func (b *schemaBundler) rewriteRef(ref string, baseID string, baseDir string, prefix string) string {
+	uri, fragment := ref, ""
+	if i := strings.Index(ref, "#"); i >= 0 {
+		uri, fragment = ref[:i], ref[i+1:]
+	}
+	// only JSON pointer fragments can be moved into the definitions
+	if fragment != "" && !strings.HasPrefix(fragment, "/") {
+		if uri != "" && isRemoteSchema(resolveRefURI(uri, baseID)) && b.err == nil {
+			b.err = fmt.Errorf("cannot bundle the remote $ref %v, only JSON pointer fragments are supported", ref)
+		}
+		return ref
+	}
+
+	if uri == "" {
+		return "#" + prefix + fragment
+	}
+
+	rs, err := b.reg.lookup(uri, baseID, baseDir)
+	if err != nil && b.err == nil {
+		b.err = fmt.Errorf("cannot resolve $ref %v: %v", ref, err)
+	}
+	if rs == nil {
+		return ref
+	}
+	if rs.path == b.root.path || (rs.id != "" && rs.id == b.root.id) {
+		return "#" + fragment
+	}
+
+	return "#" + b.bundle(rs) + fragment
+}
+
+// bundle copies the schema into the definitions once, returning its location
+func (b *schemaBundler) bundle(rs *registeredSchema) (location string) {
+	if rs.key == "" {
+		rs.key = b.reg.keyOf(rs)
+	}
+	location = "/definitions/" + escapePointer(rs.key)
+	if b.bundled[rs] {
+		return location
+	}
+	b.bundled[rs] = true
+
+	def := b.rewrite(rs.doc, rs.id, filepath.Dir(rs.path), location)
+	if m, ok := def.(map[string]interface{}); ok {
+		delete(m, "$id")
+		delete(m, "id")
+		delete(m, "$schema")
+	}
+	b.defs[rs.key] = def
+	return location
+}
+
+// keyOf names the schema by its path relative to the schema directories, or
+// else by its file name or the file name of its URL, unique across the registry
+func (reg *schemaRegistry) keyOf(rs *registeredSchema) string {
+	key := filepath.Base(rs.path)
+	if u, err := url.Parse(rs.url); err == nil && rs.url != "" {
+		if base := path.Base(u.Path); base != "." && base != "/" {
+			key = base
+		}
+	}
+	for _, dir := range reg.dirs {
+		if rel, err := filepath.Rel(dir, rs.path); err == nil && !strings.HasPrefix(rel, "..") {
+			key = filepath.ToSlash(rel)
+			break
+		}
+	}
+
+	used := map[string]bool{}
+	for _, other := range reg.byPath {
+		if other != rs && other.key != "" {
+			used[other.key] = true
+		}
+	}
+	unique := key
+	for i := 2; used[unique]; i++ {
+		unique = fmt.Sprintf("%v.%d", key, i)
+	}
+	return unique
+}
+
+// escapePointer escapes a JSON pointer token, also for use in a URI fragment
+func escapePointer(token string) string {
+	token = strings.Replace(token, "~", "~0", -1)
+	token = strings.Replace(token, "/", "~1", -1)
+	return (&url.URL{Fragment: token}).EscapedFragment()
+}
@@ -0,0 +1,235 @@
+package qa
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// writeTestFiles writes the files under dir, creating their directories
+func writeTestFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// bundleTestSchema bundles the schema file with the registry of the schema dirs
+func bundleTestSchema(t *testing.T, schemaDirs []string, file string) map[string]interface{} {
+	t.Helper()
+	reg, err := newSchemaRegistry(schemaDirs, []string{file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundled, err := bundleSchemaRefs(reg, data, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := map[string]interface{}{}
+	if err := json.Unmarshal(bundled, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// validateTestRecord validates the record against the bundled schema alone, so
+// any reference left outside of the schema fails
+func validateTestRecord(t *testing.T, schema map[string]interface{}, record string) bool {
+	t.Helper()
+	result, err := gojsonschema.Validate(gojsonschema.NewGoLoader(schema), gojsonschema.NewStringLoader(record))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result.Valid()
+}
+
+func definitionsOf(t *testing.T, doc map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	defs, ok := doc["definitions"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("got no definitions in %v", doc)
+	}
+	return defs
+}
+
+func refAt(t *testing.T, doc map[string]interface{}, keys ...string) string {
+	t.Helper()
+	var node interface{} = doc
+	for _, k := range keys {
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			t.Fatalf("got no %v in %v", k, node)
+		}
+		node = m[k]
+	}
+	ref, _ := node.(map[string]interface{})["$ref"].(string)
+	return ref
+}
+
+func TestBundleSchemaRefsNameCollisions(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"person.json": `{
+			"type": "object",
+			"properties": {
+				"home": {"$ref": "home/address.json"},
+				"work": {"$ref": "work/address.json"}
+			}
+		}`,
+		"home/address.json": `{"type": "object", "required": ["street"]}`,
+		"work/address.json": `{"type": "object", "required": ["company"]}`,
+	})
+
+	doc := bundleTestSchema(t, nil, filepath.Join(dir, "person.json"))
+	defs := definitionsOf(t, doc)
+	if len(defs) != 2 || defs["address.json"] == nil || defs["address.json.2"] == nil {
+		t.Fatalf("got definitions %v, want address.json and address.json.2", defs)
+	}
+
+	home := refAt(t, doc, "properties", "home")
+	work := refAt(t, doc, "properties", "work")
+	if home == work {
+		t.Errorf("got the same $ref %v for both addresses", home)
+	}
+
+	if !validateTestRecord(t, doc, `{"home": {"street": "Main"}, "work": {"company": "DataHen"}}`) {
+		t.Error("got a valid record rejected")
+	}
+	if validateTestRecord(t, doc, `{"home": {"company": "DataHen"}, "work": {"street": "Main"}}`) {
+		t.Error("got the addresses validated against each other's schema")
+	}
+}
+
+func TestBundleSchemaRefsCycles(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"person.json": `{
+			"$id": "https://example.com/person.json",
+			"type": "object",
+			"properties": {
+				"name": {"type": "string"},
+				"manager": {"$ref": "person.json"},
+				"team": {"$ref": "team.json"}
+			}
+		}`,
+		"team.json": `{
+			"type": "object",
+			"properties": {
+				"lead": {"$ref": "person.json"},
+				"parent": {"$ref": "team.json"},
+				"members": {"type": "array", "items": {"$ref": "#/definitions/member"}}
+			},
+			"definitions": {
+				"member": {"$ref": "person.json#/properties/name"}
+			}
+		}`,
+	})
+
+	doc := bundleTestSchema(t, nil, filepath.Join(dir, "person.json"))
+	defs := definitionsOf(t, doc)
+	if len(defs) != 1 || defs["team.json"] == nil {
+		t.Fatalf("got definitions %v, want team.json only", defs)
+	}
+
+	tests := []struct {
+		keys []string
+		want string
+	}{
+		{[]string{"properties", "manager"}, "#"},
+		{[]string{"properties", "team"}, "#/definitions/team.json"},
+		{[]string{"definitions", "team.json", "properties", "lead"}, "#"},
+		{[]string{"definitions", "team.json", "properties", "parent"}, "#/definitions/team.json"},
+		{[]string{"definitions", "team.json", "definitions", "member"}, "#/properties/name"},
+		{[]string{"definitions", "team.json", "properties", "members", "items"}, "#/definitions/team.json/definitions/member"},
+	}
+	for _, tt := range tests {
+		if got := refAt(t, doc, tt.keys...); got != tt.want {
+			t.Errorf("got $ref %q at %v, want %q", got, tt.keys, tt.want)
+		}
+	}
+
+	if !validateTestRecord(t, doc, `{"name": "a", "manager": {"name": "b"}, "team": {"lead": {"name": "c"}, "parent": {"members": ["d"]}}}`) {
+		t.Error("got a valid record rejected")
+	}
+	if validateTestRecord(t, doc, `{"team": {"parent": {"members": [1]}}}`) {
+		t.Error("got an invalid nested member accepted")
+	}
+}
+
+func TestBundleSchemaRefsSchemaDirs(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"schemas/product.json": `{
+			"type": "object",
+			"properties": {
+				"price": {"$ref": "types/money.json"},
+				"url": {"$ref": "https://example.com/types/url.json"}
+			}
+		}`,
+		"common/types/money.json": `{
+			"type": "object",
+			"required": ["amount", "currency"],
+			"properties": {
+				"amount": {"type": "number"},
+				"currency": {"$ref": "./currency.json"}
+			}
+		}`,
+		"common/types/currency.json": `{"type": "string", "enum": ["USD", "CAD"]}`,
+		"common/url.yaml":            "$id: https://example.com/types/url.json\ntype: string\npattern: ^https?://\n",
+	})
+
+	doc := bundleTestSchema(t, []string{filepath.Join(dir, "common")}, filepath.Join(dir, "schemas", "product.json"))
+	defs := definitionsOf(t, doc)
+	for _, key := range []string{"types/money.json", "types/currency.json", "url.yaml"} {
+		if defs[key] == nil {
+			t.Errorf("got no definition %v in %v", key, defs)
+		}
+	}
+
+	if got, want := refAt(t, doc, "properties", "price"), "#/definitions/types~1money.json"; got != want {
+		t.Errorf("got price $ref %q, want %q", got, want)
+	}
+	if got, want := refAt(t, doc, "definitions", "types/money.json", "properties", "currency"), "#/definitions/types~1currency.json"; got != want {
+		t.Errorf("got currency $ref %q, want %q", got, want)
+	}
+
+	if !validateTestRecord(t, doc, `{"price": {"amount": 1.5, "currency": "CAD"}, "url": "https://example.com"}`) {
+		t.Error("got a valid record rejected")
+	}
+	if validateTestRecord(t, doc, `{"price": {"amount": 1.5, "currency": "EUR"}}`) {
+		t.Error("got an unknown currency accepted")
+	}
+	if validateTestRecord(t, doc, `{"url": "example.com"}`) {
+		t.Error("got an invalid url accepted")
+	}
+}
+
+func TestBundleSchemaRefsUnresolved(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"person.json": `{"properties": {"address": {"$ref": "missing.json#/address"}}}`,
+	})
+
+	doc := bundleTestSchema(t, nil, filepath.Join(dir, "person.json"))
+	if _, ok := doc["definitions"]; ok {
+		t.Errorf("got definitions %v for an unresolved $ref", doc["definitions"])
+	}
+	if got, want := refAt(t, doc, "properties", "address"), "missing.json#/address"; got != want {
+		t.Errorf("got $ref %q, want it kept as %q", got, want)
+	}
+}
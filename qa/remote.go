@@ -0,0 +1,248 @@
+package qa
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultSchemaFetchTimeout limits the time fetching each remote schema
+const DefaultSchemaFetchTimeout = 30 * time.Second
+
+// schemaPinPrefix starts the URL fragment pinning the SHA-256 checksum of a
+// remote schema, as in https://example.com/person.json#sha256=<hex>
+const schemaPinPrefix = "sha256="
+
+// isRemoteSchema tells whether the schema is an HTTP(S) URL
+func isRemoteSchema(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// ValidateSchemaURLs returns an error when the checksum pinning a remote
+// schema is malformed
+func ValidateSchemaURLs(schemas []string) (err error) {
+	for _, s := range schemas {
+		if !isRemoteSchema(s) {
+			continue
+		}
+		if _, _, err := splitSchemaPin(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remoteSchemaMeta is kept next to each cached schema to revalidate it
+type remoteSchemaMeta struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	FetchedAt    time.Time `json:"fetched_at"`
+}
+
+// defaultSchemaCacheDir returns the user cache directory of remote schemas
+func defaultSchemaCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "henqa", "schemas")
+}
+
+// splitSchemaPin splits the pinned checksum off the schema URL
+func splitSchemaPin(s string) (rawURL string, checksum string, err error) {
+	i := strings.Index(s, "#")
+	if i < 0 {
+		return s, "", nil
+	}
+	rawURL, fragment := s[:i], s[i+1:]
+	if !strings.HasPrefix(fragment, schemaPinPrefix) {
+		return "", "", fmt.Errorf("invalid schema URL %v, expected the checksum as #sha256=<hex>", s)
+	}
+	checksum = strings.ToLower(strings.TrimPrefix(fragment, schemaPinPrefix))
+	if b, err := hex.DecodeString(checksum); err != nil || len(b) != sha256.Size {
+		return "", "", fmt.Errorf("invalid sha256 checksum %q of schema %v", checksum, rawURL)
+	}
+	return rawURL, checksum, nil
+}
+
+// cachedSchemaPaths returns the cache files of the schema URL, keyed by the
+// URL hash and keeping the URL extension so YAML schemas are read as such
+func cachedSchemaPaths(cacheDir string, rawURL string) (body string, meta string) {
+	sum := sha256.Sum256([]byte(rawURL))
+	key := hex.EncodeToString(sum[:])
+
+	ext := ".json"
+	if u, err := url.Parse(rawURL); err == nil {
+		switch e := path.Ext(u.Path); e {
+		case ".yaml", ".yml":
+			ext = e
+		}
+	}
+	return filepath.Join(cacheDir, key+ext), filepath.Join(cacheDir, key+".meta.json")
+}
+
+// fetchRemoteSchemas downloads the remote schemas into the cache, returning
+// the schema files with the URLs replaced by their cached copies and the
+// URL of each cached copy
+func (v *Validator) fetchRemoteSchemas(ctx context.Context, schemas []string) (files []string, urls map[string]string, err error) {
+	urls = map[string]string{}
+	for _, s := range schemas {
+		if !isRemoteSchema(s) {
+			files = append(files, s)
+			continue
+		}
+
+		rawURL, checksum, err := splitSchemaPin(s)
+		if err != nil {
+			return nil, nil, err
+		}
+		f, err := v.fetchRemoteSchema(ctx, rawURL, checksum)
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, f)
+		urls[f] = rawURL
+	}
+	return files, urls, nil
+}
+
+// newSchemaRegistry registers the schema files and the cached copies of the
+// remote schemas under their URL. The remote schemas referenced by $ref are
+// fetched through the cache the same way, unpinned.
+func (v *Validator) newSchemaRegistry(ctx context.Context, files []string, remoteURLs map[string]string) (reg *schemaRegistry, err error) {
+	reg, err = newSchemaRegistry(v.opts.SchemaDirs, files)
+	if err != nil {
+		return nil, err
+	}
+	for f, u := range remoteURLs {
+		reg.alias(u, f)
+	}
+	reg.fetch = func(rawURL string) (path string, err error) {
+		return v.fetchRemoteSchema(ctx, rawURL, "")
+	}
+	return reg, nil
+}
+
+// fetchRemoteSchema revalidates the cached copy of the schema with its ETag,
+// downloading it when changed. Offline runs and pinned schemas whose cached
+// copy matches the checksum use the cache only.
+func (v *Validator) fetchRemoteSchema(ctx context.Context, rawURL string, checksum string) (file string, err error) {
+	cacheDir := v.opts.SchemaCacheDir
+	bodyPath, metaPath := cachedSchemaPaths(cacheDir, rawURL)
+
+	cached, cacheErr := ioutil.ReadFile(bodyPath)
+	meta := remoteSchemaMeta{}
+	if data, err := ioutil.ReadFile(metaPath); err == nil {
+		json.Unmarshal(data, &meta)
+	}
+
+	if cacheErr == nil && checksum != "" && schemaChecksum(cached) == checksum {
+		return bodyPath, nil
+	}
+	if v.opts.Offline {
+		if cacheErr != nil {
+			return "", fmt.Errorf("schema %v is not cached, cannot fetch it offline", rawURL)
+		}
+		return bodyPath, verifySchemaChecksum(rawURL, cached, checksum)
+	}
+
+	body, notModified, err := v.downloadSchema(ctx, rawURL, &meta, cacheErr == nil)
+	if err != nil {
+		if cacheErr != nil {
+			return "", err
+		}
+		v.logf("cannot fetch schema %v, using the cached copy: %v\n", rawURL, err)
+		return bodyPath, verifySchemaChecksum(rawURL, cached, checksum)
+	}
+	if notModified {
+		return bodyPath, verifySchemaChecksum(rawURL, cached, checksum)
+	}
+
+	if err := verifySchemaChecksum(rawURL, body, checksum); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(cacheDir, 0755); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(bodyPath, body, 0644); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(metaPath, data, 0644); err != nil {
+		return "", err
+	}
+	v.logln("fetched schema:", rawURL)
+
+	return bodyPath, nil
+}
+
+// downloadSchema gets the schema, conditionally on the cached ETag and last
+// modification time, updating them in meta
+func (v *Validator) downloadSchema(ctx context.Context, rawURL string, meta *remoteSchemaMeta, cached bool) (body []byte, notModified bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if cached && meta.URL == rawURL {
+		if meta.ETag != "" {
+			req.Header.Set("If-None-Match", meta.ETag)
+		}
+		if meta.LastModified != "" {
+			req.Header.Set("If-Modified-Since", meta.LastModified)
+		}
+	}
+
+	resp, err := v.opts.HTTPClient.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached:
+		return nil, true, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, false, fmt.Errorf("cannot fetch schema %v: %v", rawURL, resp.Status)
+	}
+
+	body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, false, err
+	}
+	meta.URL = rawURL
+	meta.ETag = resp.Header.Get("ETag")
+	meta.LastModified = resp.Header.Get("Last-Modified")
+	meta.FetchedAt = time.Now().UTC()
+	return body, false, nil
+}
+
+func schemaChecksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// verifySchemaChecksum returns an error when the schema doesn't match its
+// pinned checksum, if any
+func verifySchemaChecksum(rawURL string, data []byte, checksum string) (err error) {
+	if checksum == "" {
+		return nil
+	}
+	if got := schemaChecksum(data); got != checksum {
+		return fmt.Errorf("schema %v checksum mismatch: expected sha256 %v, got %v", rawURL, checksum, got)
+	}
+	return nil
+}
@@ -0,0 +1,251 @@
+package qa
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// schemaServer serves schemas by path with their ETag, counting the requests
+// and the 304 responses
+type schemaServer struct {
+	*httptest.Server
+
+	mu          sync.Mutex
+	schemas     map[string]string
+	requests    map[string]int
+	notModified int
+}
+
+func newSchemaServer(t *testing.T, schemas map[string]string) *schemaServer {
+	s := &schemaServer{schemas: schemas, requests: map[string]int{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.requests[r.URL.Path]++
+		body, ok := s.schemas[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		etag := fmt.Sprintf("%q", schemaChecksum([]byte(body))[:16])
+		if r.Header.Get("If-None-Match") == etag {
+			s.notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *schemaServer) set(path string, body string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.schemas[path] = body
+}
+
+func (s *schemaServer) count(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+func newRemoteTestValidator(s *schemaServer, cacheDir string, offline bool) *Validator {
+	return NewValidator(Options{
+		SchemaCacheDir: cacheDir,
+		Offline:        offline,
+		HTTPClient:     s.Client(),
+		Logger:         ioutil.Discard,
+	})
+}
+
+func readTestFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestFetchRemoteSchemaRevalidates(t *testing.T) {
+	s := newSchemaServer(t, map[string]string{"/person.json": `{"type": "object"}`})
+	v := newRemoteTestValidator(s, t.TempDir(), false)
+	ctx := context.Background()
+
+	path, err := v.fetchRemoteSchema(ctx, s.URL+"/person.json", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, path); got != `{"type": "object"}` {
+		t.Errorf("got cached schema %v", got)
+	}
+
+	// unchanged schemas are revalidated with their ETag
+	again, err := v.fetchRemoteSchema(ctx, s.URL+"/person.json", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != path || s.count("/person.json") != 2 || s.notModified != 1 {
+		t.Errorf("got %v requests and %v not modified responses, want 2 and 1", s.count("/person.json"), s.notModified)
+	}
+
+	// changed schemas replace the cached copy
+	s.set("/person.json", `{"type": "array"}`)
+	if _, err := v.fetchRemoteSchema(ctx, s.URL+"/person.json", ""); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, path); got != `{"type": "array"}` {
+		t.Errorf("got cached schema %v after a change", got)
+	}
+	if s.notModified != 1 {
+		t.Errorf("got %v not modified responses, want 1", s.notModified)
+	}
+}
+
+func TestFetchRemoteSchemaOffline(t *testing.T) {
+	s := newSchemaServer(t, map[string]string{"/person.json": `{"type": "object"}`})
+	cacheDir := t.TempDir()
+	ctx := context.Background()
+
+	// without a cached copy
+	_, err := newRemoteTestValidator(s, cacheDir, true).fetchRemoteSchema(ctx, s.URL+"/person.json", "")
+	if err == nil || !strings.Contains(err.Error(), "not cached") {
+		t.Errorf("got error %v, want the schema not cached", err)
+	}
+	if n := s.count("/person.json"); n != 0 {
+		t.Errorf("got %v requests offline", n)
+	}
+
+	// with a cached copy
+	if _, err := newRemoteTestValidator(s, cacheDir, false).fetchRemoteSchema(ctx, s.URL+"/person.json", ""); err != nil {
+		t.Fatal(err)
+	}
+	path, err := newRemoteTestValidator(s, cacheDir, true).fetchRemoteSchema(ctx, s.URL+"/person.json", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFile(t, path); got != `{"type": "object"}` {
+		t.Errorf("got cached schema %v", got)
+	}
+	if n := s.count("/person.json"); n != 1 {
+		t.Errorf("got %v requests, want 1 before going offline", n)
+	}
+}
+
+func TestFetchRemoteSchemaChecksum(t *testing.T) {
+	body := `{"type": "object"}`
+	s := newSchemaServer(t, map[string]string{"/person.json": body})
+	cacheDir := t.TempDir()
+	ctx := context.Background()
+	v := newRemoteTestValidator(s, cacheDir, false)
+	rawURL := s.URL + "/person.json"
+
+	wrong := schemaChecksum([]byte(`{"type": "array"}`))
+	_, err := v.fetchRemoteSchema(ctx, rawURL, wrong)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("got error %v, want a checksum mismatch", err)
+	}
+	bodyPath, _ := cachedSchemaPaths(cacheDir, rawURL)
+	if _, err := os.Stat(bodyPath); !os.IsNotExist(err) {
+		t.Errorf("got a mismatching schema cached: %v", err)
+	}
+
+	// pinned schemas matching their cached copy are not revalidated
+	pinned := schemaChecksum([]byte(body))
+	for i := 0; i < 2; i++ {
+		if _, err := v.fetchRemoteSchema(ctx, rawURL, pinned); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := s.count("/person.json"); n != 2 {
+		t.Errorf("got %v requests, want 2", n)
+	}
+
+	// a cached copy that no longer matches fails offline
+	if err := ioutil.WriteFile(bodyPath, []byte(`{}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = newRemoteTestValidator(s, cacheDir, true).fetchRemoteSchema(ctx, rawURL, pinned)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("got error %v offline, want a checksum mismatch", err)
+	}
+}
+
+// bundleRemoteTestSchema fetches and bundles the remote schema the way a run does
+func bundleRemoteTestSchema(v *Validator, rawURL string) (schema []byte, err error) {
+	ctx := context.Background()
+	files, urls, err := v.fetchRemoteSchemas(ctx, []string{rawURL})
+	if err != nil {
+		return nil, err
+	}
+	reg, err := v.newSchemaRegistry(ctx, files, urls)
+	if err != nil {
+		return nil, err
+	}
+	return v.getAndMergeSchemaFiles(files, reg)
+}
+
+func TestBundleRemoteSchemaRefs(t *testing.T) {
+	s := newSchemaServer(t, nil)
+	s.schemas = map[string]string{
+		"/schemas/person.json": `{
+			"$id": "` + s.URL + `/schemas/person.json",
+			"type": "object",
+			"properties": {
+				"address": {"$ref": "types/address.json"},
+				"country": {"$ref": "` + s.URL + `/schemas/types/address.json#/properties/country"}
+			}
+		}`,
+		"/schemas/types/address.json": `{"type": "object", "properties": {"country": {"type": "string"}}}`,
+	}
+	cacheDir := t.TempDir()
+	rawURL := s.URL + "/schemas/person.json"
+
+	// the referenced schema isn't cached yet
+	if _, err := newRemoteTestValidator(s, cacheDir, false).fetchRemoteSchema(context.Background(), rawURL, ""); err != nil {
+		t.Fatal(err)
+	}
+	_, err := bundleRemoteTestSchema(newRemoteTestValidator(s, cacheDir, true), rawURL)
+	if err == nil || !strings.Contains(err.Error(), "not cached") {
+		t.Errorf("got error %v offline, want the referenced schema not cached", err)
+	}
+
+	schema, err := bundleRemoteTestSchema(newRemoteTestValidator(s, cacheDir, false), rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := map[string]interface{}{}
+	if err := json.Unmarshal(schema, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := refAt(t, doc, "properties", "address"), "#/definitions/address.json"; got != want {
+		t.Errorf("got address $ref %q, want %q", got, want)
+	}
+	if got, want := refAt(t, doc, "properties", "country"), "#/definitions/address.json/properties/country"; got != want {
+		t.Errorf("got country $ref %q, want %q", got, want)
+	}
+	if n := s.count("/schemas/types/address.json"); n != 1 {
+		t.Errorf("got %v requests of the referenced schema, want 1", n)
+	}
+
+	// the referenced schema is cached now
+	requests := s.count("/schemas/person.json") + s.count("/schemas/types/address.json")
+	if _, err := bundleRemoteTestSchema(newRemoteTestValidator(s, cacheDir, true), rawURL); err != nil {
+		t.Fatal(err)
+	}
+	if n := s.count("/schemas/person.json") + s.count("/schemas/types/address.json"); n != requests {
+		t.Errorf("got %v requests offline", n-requests)
+	}
+}
@@ -0,0 +1,79 @@
+package qa
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/DataHenHQ/henqa_shared/customtypes"
+)
+
+// readOverallSummaryFile reads the overall summary saved by a validation
+func readOverallSummaryFile(outDir string, summaryFile string) (summaryErrStats map[string]customtypes.ErrorStats, err error) {
+	summaryFileName := filepath.Join(outDir, fmt.Sprintf("%v.json", summaryFile))
+	data, err := readFile(summaryFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	summaryErrStats = map[string]customtypes.ErrorStats{}
+	if err := json.Unmarshal(data, &summaryErrStats); err != nil {
+		return nil, fmt.Errorf("invalid summary file %v: %v", summaryFileName, err)
+	}
+	return summaryErrStats, nil
+}
+
+// readStatusFile reads the status saved next to the overall summary. Reports
+// written before the status was saved have none, os.IsNotExist tells them.
+func readStatusFile(outDir string, summaryFile string) (status validationStatus, err error) {
+	statusFileName := filepath.Join(outDir, fmt.Sprintf("%v.status.json", summaryFile))
+	data, err := readFile(statusFileName)
+	if err != nil {
+		return status, err
+	}
+
+	if err := json.Unmarshal(data, &status); err != nil {
+		return status, fmt.Errorf("invalid status file %v: %v", statusFileName, err)
+	}
+	return status, nil
+}
+
+// readDetailFile streams the records with errors saved in the details file of
+// a report key, either a JSON array or NDJSON, until fn returns false
+func readDetailFile(outDir string, reportKey string, fn func(rw RecordWrapper) (more bool)) (err error) {
+	detailsFile := fmt.Sprintf("%v.json", filepath.Join(outDir, "details", filepath.FromSlash(reportKey)))
+	f, err := os.Open(detailsFile)
+	if os.IsNotExist(err) {
+		detailsFile = strings.TrimSuffix(detailsFile, ".json") + ".ndjson"
+		f, err = os.Open(detailsFile)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// skip the array start of JSON details, NDJSON is a plain stream of values
+	dec := json.NewDecoder(f)
+	if strings.HasSuffix(detailsFile, ".json") {
+		if _, err := dec.Token(); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("invalid details file %v: %v", detailsFile, err)
+		}
+	}
+	for dec.More() {
+		rw := RecordWrapper{}
+		if err := dec.Decode(&rw); err != nil {
+			return fmt.Errorf("invalid details file %v: %v", detailsFile, err)
+		}
+		if !fn(rw) {
+			return nil
+		}
+	}
+
+	return nil
+}
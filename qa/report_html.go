@@ -0,0 +1,226 @@
+package qa
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/DataHenHQ/datahen/records"
+	"github.com/DataHenHQ/henqa_shared/customtypes"
+)
+
+// DefaultHTMLSamples is the number of failing records shown per field in HTML reports.
+const DefaultHTMLSamples = 5
+
+type htmlReport struct {
+	Title     string
+	Generated string
+	Files     []htmlFile
+}
+
+type htmlFile struct {
+	Key         string
+	RecordCount uint64
+	// HasRecordCount is false for the files without errors of reports saved
+	// without their record counts
+	HasRecordCount bool
+	ErrorCount     uint64
+	Stats          []*customtypes.ErrorStat
+	Fields         []*htmlField
+}
+
+type htmlField struct {
+	Name    string
+	Stats   []*customtypes.ErrorStat
+	Samples []htmlSample
+}
+
+type htmlSample struct {
+	Errors []records.SchemaError
+	Record string
+}
+
+// WriteHTMLReport renders the summary and details of a reports folder into a
+// self-contained static HTML file, keeping up to maxSamples failing records
+// per field.
+func WriteHTMLReport(outDir string, summaryFile string, htmlFile string, maxSamples int) (err error) {
+	summaryErrStats, err := readOverallSummaryFile(outDir, summaryFile)
+	if err != nil {
+		return err
+	}
+
+	report := htmlReport{
+		Title:     fmt.Sprintf("henqa report: %v", outDir),
+		Generated: time.Now().Format(time.RFC1123),
+	}
+	keys := make([]string, 0, len(summaryErrStats))
+	for key := range summaryErrStats {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// the status counts the records of the files without errors too
+	status, err := readStatusFile(outDir, summaryFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, key := range keys {
+		hf, err := buildHTMLFile(outDir, key, summaryErrStats[key], maxSamples)
+		if err != nil {
+			return err
+		}
+		if count, ok := status.FileRecordCounts[key]; ok {
+			hf.RecordCount = count
+			hf.HasRecordCount = true
+		}
+		report.Files = append(report.Files, hf)
+	}
+
+	f, err := os.Create(htmlFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return htmlReportTemplate.Execute(f, report)
+}
+
+func buildHTMLFile(outDir string, key string, errStats customtypes.ErrorStats, maxSamples int) (hf htmlFile, err error) {
+	hf = htmlFile{Key: key}
+
+	// group the error stats by field
+	fields := map[string]*htmlField{}
+	for _, errKey := range sortedErrKeys(errStats) {
+		es := errStats[errKey]
+		hf.RecordCount = es.RecordCount
+		hf.HasRecordCount = true
+		hf.ErrorCount += es.ErrorCount
+		hf.Stats = append(hf.Stats, es)
+
+		if fields[es.Field] == nil {
+			fields[es.Field] = &htmlField{Name: es.Field}
+			hf.Fields = append(hf.Fields, fields[es.Field])
+		}
+		fields[es.Field].Stats = append(fields[es.Field].Stats, es)
+	}
+	sort.Slice(hf.Fields, func(i, j int) bool {
+		return hf.Fields[i].Name < hf.Fields[j].Name
+	})
+	if len(fields) == 0 || maxSamples < 1 {
+		return hf, nil
+	}
+
+	// pick sample failing records of each field from the details
+	missing := len(fields)
+	err = readDetailFile(outDir, key, func(rw RecordWrapper) bool {
+		seen := map[string]bool{}
+		for _, e := range rw.Errors {
+			field := fields[e.Field]
+			if field == nil || seen[e.Field] || len(field.Samples) >= maxSamples {
+				continue
+			}
+			seen[e.Field] = true
+
+			rec, err := json.MarshalIndent(rw.Record, "", "  ")
+			if err != nil {
+				continue
+			}
+			field.Samples = append(field.Samples, htmlSample{Errors: rw.Errors, Record: string(rec)})
+			if len(field.Samples) == maxSamples {
+				missing--
+			}
+		}
+		return missing > 0
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return hf, err
+	}
+
+	return hf, nil
+}
+
+func formatPercent(v interface{}) string {
+	switch p := v.(type) {
+	case float64:
+		return fmt.Sprintf("%.2f%%", p)
+	case float32:
+		return fmt.Sprintf("%.2f%%", p)
+	}
+	return fmt.Sprintf("%v%%", v)
+}
+
+var htmlReportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"percent": formatPercent,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; margin: 2em; color: #222; }
+h1 { font-size: 1.6em; }
+h2 { font-size: 1.3em; margin-top: 2em; border-bottom: 1px solid #ddd; padding-bottom: .3em; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { border: 1px solid #ddd; padding: .4em .8em; text-align: left; vertical-align: top; }
+th { background: #f5f5f5; }
+td.num { text-align: right; }
+.ok { color: #2a7d2a; }
+.fail { color: #b62324; }
+details { margin: .5em 0 .5em 1em; }
+summary { cursor: pointer; font-weight: bold; }
+pre { background: #f8f8f8; padding: .8em; overflow-x: auto; font-size: .85em; }
+.muted { color: #777; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="muted">Generated {{.Generated}}</p>
+
+<h2>Files</h2>
+<table>
+<tr><th>File</th><th>Records</th><th>Errors</th><th>Error types</th></tr>
+{{range .Files}}<tr>
+<td><a href="#{{.Key}}">{{.Key}}</a></td>
+<td class="num">{{if .HasRecordCount}}{{.RecordCount}}{{else}}-{{end}}</td>
+<td class="num {{if .ErrorCount}}fail{{else}}ok{{end}}">{{.ErrorCount}}</td>
+<td class="num">{{len .Stats}}</td>
+</tr>
+{{end}}</table>
+
+{{range .Files}}
+<h2 id="{{.Key}}">{{.Key}}</h2>
+{{if .Stats}}
+<table>
+<tr><th>Field</th><th>Error type</th><th>Description</th><th>Errors</th><th>Records</th><th>Percent</th></tr>
+{{range .Stats}}<tr>
+<td>{{.Field}}</td><td>{{.ErrorType}}</td><td>{{.ErrorDescription}}</td>
+<td class="num">{{.ErrorCount}}</td><td class="num">{{.RecordCount}}</td><td class="num">{{percent .ErrorPercent}}</td>
+</tr>
+{{end}}</table>
+{{range .Fields}}
+<details>
+<summary>{{.Name}}</summary>
+<ul>
+{{range .Stats}}<li>{{.ErrorType}}: {{.ErrorCount}} errors ({{percent .ErrorPercent}}) {{.ErrorDescription}}</li>
+{{end}}</ul>
+{{range .Samples}}
+<table>
+<tr><th>Field</th><th>Error type</th><th>Description</th><th>Value</th></tr>
+{{range .Errors}}<tr><td>{{.Field}}</td><td>{{.ErrorType}}</td><td>{{.Description}}</td><td>{{printf "%v" .Value}}</td></tr>
+{{end}}</table>
+<pre>{{.Record}}</pre>
+{{else}}<p class="muted">No sample records saved in the details file.</p>
+{{end}}
+</details>
+{{end}}
+{{else}}
+<p class="ok">No errors found.</p>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,54 @@
+package qa
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteHTMLReportRecordCounts(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"data/valid.ndjson":   "{\"id\": 1}\n{\"id\": 2}\n{\"id\": 3}\n",
+		"data/invalid.ndjson": "{\"id\": 1}\n{\"id\": \"x\"}\n",
+	})
+
+	opts := newTestOptions(t, dir, filepath.Join(dir, "data"))
+	opts.Formats = []string{"html"}
+	if _, err := NewValidator(opts).Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	htmlFile := filepath.Join(opts.OutDir, "summary.html")
+	data, err := ioutil.ReadFile(htmlFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the files without errors show their record count too
+	for _, row := range []string{
+		"<td><a href=\"#invalid.ndjson\">invalid.ndjson</a></td>\n<td class=\"num\">2</td>",
+		"<td><a href=\"#valid.ndjson\">valid.ndjson</a></td>\n<td class=\"num\">3</td>",
+	} {
+		if !strings.Contains(string(data), row) {
+			t.Errorf("got no row %q in the report:\n%s", row, data)
+		}
+	}
+
+	// reports saved without the record counts show them for files with errors only
+	if err := os.Remove(filepath.Join(opts.OutDir, "summary.status.json")); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteHTMLReport(opts.OutDir, "summary", htmlFile, DefaultHTMLSamples); err != nil {
+		t.Fatal(err)
+	}
+	data, err = ioutil.ReadFile(htmlFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "<td><a href=\"#valid.ndjson\">valid.ndjson</a></td>\n<td class=\"num\">-</td>") {
+		t.Errorf("got no unknown record count in the report:\n%s", data)
+	}
+}
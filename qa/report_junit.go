@@ -0,0 +1,153 @@
+package qa
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/DataHenHQ/henqa_shared/customtypes"
+)
+
+// DefaultJUnitValues is the number of offending values shown per failing test case.
+const DefaultJUnitValues = 3
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnitReport writes the summary of a reports folder as JUnit XML, where
+// each input file is a test suite and each field.error_type is a failing test
+// case listing up to maxValues offending values from the details.
+func WriteJUnitReport(outDir string, summaryFile string, xmlFile string, maxValues int) (err error) {
+	summaryErrStats, err := readOverallSummaryFile(outDir, summaryFile)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(summaryErrStats))
+	for key := range summaryErrStats {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	suites := junitTestSuites{Name: summaryFile}
+	for _, key := range keys {
+		suite, err := buildJUnitSuite(outDir, key, summaryErrStats[key], maxValues)
+		if err != nil {
+			return err
+		}
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Suites = append(suites.Suites, suite)
+	}
+
+	data, err := xml.MarshalIndent(suites, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append([]byte(xml.Header), data...)
+	return ioutil.WriteFile(xmlFile, data, 0644)
+}
+
+func buildJUnitSuite(outDir string, key string, errStats customtypes.ErrorStats, maxValues int) (suite junitTestSuite, err error) {
+	suite = junitTestSuite{Name: key}
+
+	// a file without errors is a single passing test case
+	if len(errStats) == 0 {
+		suite.Tests = 1
+		suite.TestCases = []junitTestCase{{Name: "valid", ClassName: key}}
+		return suite, nil
+	}
+
+	values, err := offendingValues(outDir, key, errStats, maxValues)
+	if err != nil {
+		return suite, err
+	}
+
+	for _, errKey := range sortedErrKeys(errStats) {
+		es := errStats[errKey]
+		msg := fmt.Sprintf("%v errors in %v records (%v)", es.ErrorCount, es.RecordCount, formatPercent(es.ErrorPercent))
+
+		text := es.ErrorDescription
+		if len(values[errKey]) > 0 {
+			text = fmt.Sprintf("%v\nOffending values:\n  %v", text, strings.Join(values[errKey], "\n  "))
+		}
+
+		suite.TestCases = append(suite.TestCases, junitTestCase{
+			Name:      errKey,
+			ClassName: key,
+			Failure: &junitFailure{
+				Message: msg,
+				Type:    es.ErrorType,
+				Text:    text,
+			},
+		})
+		suite.Tests++
+		suite.Failures++
+	}
+
+	return suite, nil
+}
+
+// offendingValues collects up to maxValues distinct values of each error key from the details
+func offendingValues(outDir string, key string, errStats customtypes.ErrorStats, maxValues int) (values map[string][]string, err error) {
+	values = map[string][]string{}
+	if maxValues < 1 {
+		return values, nil
+	}
+
+	seen := map[string]bool{}
+	missing := len(errStats)
+	err = readDetailFile(outDir, key, func(rw RecordWrapper) bool {
+		for _, e := range rw.Errors {
+			errKey := fmt.Sprintf("%v.%v", e.Field, e.ErrorType)
+			if errStats[errKey] == nil || len(values[errKey]) >= maxValues {
+				continue
+			}
+
+			value, err := json.Marshal(e.Value)
+			if err != nil || seen[errKey+"\x00"+string(value)] {
+				continue
+			}
+			seen[errKey+"\x00"+string(value)] = true
+
+			values[errKey] = append(values[errKey], string(value))
+			if len(values[errKey]) == maxValues {
+				missing--
+			}
+		}
+		return missing > 0
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return values, nil
+}
@@ -0,0 +1,67 @@
+package qa
+
+import (
+	"fmt"
+
+	"github.com/DataHenHQ/datahen/records"
+)
+
+// StdinInput is the input name that reads the records from stdin
+const StdinInput = "-"
+
+// Input formats of the records read from stdin
+const (
+	InputFormatCSV    = "csv"
+	InputFormatJSON   = "json"
+	InputFormatNDJSON = "ndjson"
+)
+
+// ValidateInputFormat returns an error when the stdin input format is unknown
+func ValidateInputFormat(format string) (err error) {
+	switch format {
+	case "", InputFormatCSV, InputFormatJSON, InputFormatNDJSON:
+		return nil
+	}
+	return fmt.Errorf("unknown input format %q", format)
+}
+
+// stdinReaderProcessor returns the stream processor of the records read from
+// stdin, by the input format or else by the extension of the stdin name,
+// falling back to NDJSON
+func (v *Validator) stdinReaderProcessor(co *csvOptions) (process processReaderFn, includeCollection bool, err error) {
+	switch v.opts.InputFormat {
+	case InputFormatCSV:
+		return csvReaderProcessor(co), false, nil
+	case InputFormatJSON:
+		return processJSONReader, true, nil
+	case InputFormatNDJSON:
+		return processJSONReader, false, nil
+	case "":
+		if process, includeCollection, ok := readerProcessorFor(logicalName(v.opts.StdinName), co); ok {
+			return process, includeCollection, nil
+		}
+		return processJSONReader, false, nil
+	}
+	return nil, false, ValidateInputFormat(v.opts.InputFormat)
+}
+
+func (v *Validator) analyzeStdin(co *csvOptions) (processFile processFileStreamFn, includeCollection bool, err error) {
+	process, includeCollection, err := v.stdinReaderProcessor(co)
+	if err != nil {
+		v.logln(err.Error())
+		return nil, false, err
+	}
+	v.logln("validating: stdin as", v.opts.StdinName)
+
+	// compressed streams are decompressed the same way as compressed files
+	processFile = func(filename string, batchSize int, configReaderFn records.ConfigReaderFn, validateFn records.ValidateFn, validatePreRecords records.ValidateHeadersFn) (err error) {
+		r, _, err := decompressReader(v.opts.Stdin)
+		if err != nil {
+			return fmt.Errorf("cannot read stdin: %v", err)
+		}
+		defer r.Close()
+
+		return process(r, batchSize, configReaderFn, validateFn, validatePreRecords)
+	}
+	return processFile, includeCollection, nil
+}
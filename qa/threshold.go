@@ -0,0 +1,101 @@
+package qa
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/DataHenHQ/henqa_shared/customtypes"
+)
+
+// Thresholds are the limits a validation result must stay within to pass.
+type Thresholds struct {
+	// FailOnErrors fails the validation when any error is found.
+	FailOnErrors bool
+	// MaxErrorPercent fails the validation when any error percentage, per file
+	// or overall, is greater than it. A negative value disables the check.
+	MaxErrorPercent float64
+	// MaxErrorIncrease fails the validation when any error percentage increased
+	// by more than its percentage points over the baseline of the result. A
+	// negative value disables the check.
+	MaxErrorIncrease float64
+}
+
+// ThresholdError is returned when a validation result exceeds its thresholds.
+type ThresholdError struct {
+	Violations []string
+}
+
+func (e *ThresholdError) Error() string {
+	return fmt.Sprintf("validation failed:\n  %v", strings.Join(e.Violations, "\n  "))
+}
+
+// CheckThresholds evaluates the error stats of the result against the
+// thresholds and returns a *ThresholdError when any of them is exceeded.
+// A result without any validated file, or with missing or skipped inputs,
+// never passes.
+func CheckThresholds(result *ValidationResult, t Thresholds) error {
+	if result == nil {
+		return nil
+	}
+
+	violations := []string{}
+	if len(result.Files) == 0 {
+		violations = append(violations, "no file was validated")
+	}
+	for _, f := range result.Missing {
+		violations = append(violations, fmt.Sprintf("%v: missing or unreadable", f))
+	}
+	for _, f := range result.Skipped {
+		violations = append(violations, fmt.Sprintf("%v: skipped", f))
+	}
+	overall := map[string]*customtypes.ErrorStat{}
+	var totalRecords uint64 = 0
+
+	files := make([]string, 0, len(result.Files))
+	for file := range result.Files {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		fr := result.Files[file]
+		totalRecords += fr.RecordCount
+
+		for _, errKey := range sortedErrKeys(fr.ErrorStats) {
+			es := fr.ErrorStats[errKey]
+			if t.FailOnErrors && es.ErrorCount > 0 {
+				violations = append(violations, fmt.Sprintf("%v: %v has %v errors", file, errKey, es.ErrorCount))
+			}
+			if t.MaxErrorPercent >= 0 && float64(es.ErrorPercent) > t.MaxErrorPercent {
+				violations = append(violations, fmt.Sprintf("%v: %v error percent %v%% is over %v%%", file, errKey, es.ErrorPercent, t.MaxErrorPercent))
+			}
+
+			// aggregate error counts for the overall percentage
+			if overall[errKey] == nil {
+				overall[errKey] = &customtypes.ErrorStat{}
+			}
+			overall[errKey].ErrorCount += es.ErrorCount
+		}
+	}
+
+	// overall percentage is taken against the records of every file
+	if t.MaxErrorPercent >= 0 && totalRecords > 0 {
+		for _, errKey := range sortedErrKeys(overall) {
+			percent := float64(overall[errKey].ErrorCount) * 100 / float64(totalRecords)
+			if percent > t.MaxErrorPercent {
+				violations = append(violations, fmt.Sprintf("overall: %v error percent %.2f%% is over %v%%", errKey, percent, t.MaxErrorPercent))
+			}
+		}
+	}
+
+	// regressions against the baseline
+	if result.Baseline != nil {
+		violations = append(violations, regressionViolations(result.Baseline, t.MaxErrorIncrease)...)
+	}
+
+	if len(violations) > 0 {
+		return &ThresholdError{Violations: violations}
+	}
+	return nil
+}
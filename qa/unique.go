@@ -0,0 +1,517 @@
+package qa
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/DataHenHQ/datahen/records"
+)
+
+// ErrorTypeDuplicateKey is the error type of the records whose unique key was
+// already used by an earlier record
+const ErrorTypeDuplicateKey = "duplicate_key"
+
+// DefaultUniqueKeyMemoryLimit is the number of unique keys held in memory
+// before they spill to disk, about 64 MB worth of keys.
+const DefaultUniqueKeyMemoryLimit = 1 << 20
+
+// recordKey joins the values of the key fields and the collection of the
+// record, empty when any key field is missing. The values are JSON encoded
+// so values of different types, such as "1" and 1, make different keys.
+func recordKey(rec records.RecordGetter, collection string, keys []string) string {
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		value, ok := rec.Get(k)
+		if !ok || value == nil || value == "" {
+			return ""
+		}
+		part, err := keyPart(value)
+		if err != nil {
+			return ""
+		}
+		parts = append(parts, part)
+	}
+	key := strings.Join(parts, "\x1f")
+	if collection != "" && collection != defaultCollection {
+		key = collection + "\x1e" + key
+	}
+	return key
+}
+
+// keyPart encodes a key value as JSON, numbers in a canonical form so the
+// same number read as json.Number, float64 or an integer makes the same key
+func keyPart(value interface{}) (part string, err error) {
+	switch val := value.(type) {
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			return strconv.FormatInt(i, 10), nil
+		}
+		if f, err := val.Float64(); err == nil {
+			return canonicalFloat(f), nil
+		}
+		return val.String(), nil
+	case float64:
+		return canonicalFloat(val), nil
+	case float32:
+		return canonicalFloat(float64(val)), nil
+	case int:
+		return strconv.FormatInt(int64(val), 10), nil
+	case int64:
+		return strconv.FormatInt(val, 10), nil
+	case int32:
+		return strconv.FormatInt(int64(val), 10), nil
+	}
+	data, err := json.Marshal(value)
+	return string(data), err
+}
+
+// canonicalFloat formats integral floats as integers
+func canonicalFloat(f float64) string {
+	if f == math.Trunc(f) && math.Abs(f) < 1<<63 {
+		return strconv.FormatInt(int64(f), 10)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// recordKeyValue returns the values of the key fields of a record key, for
+// the reports
+func recordKeyValue(key string) interface{} {
+	if i := strings.Index(key, "\x1e"); i >= 0 {
+		key = key[i+1:]
+	}
+	parts := strings.Split(key, "\x1f")
+	values := make([]interface{}, len(parts))
+	for i, part := range parts {
+		value, err := decodeJSON([]byte(part))
+		if err != nil {
+			value = part
+		}
+		values[i] = value
+	}
+	if len(values) == 1 {
+		return values[0]
+	}
+	return values
+}
+
+// keyHash is the 128-bit FNV-1a hash of a record key
+type keyHash [16]byte
+
+func hashKey(key string) (h keyHash) {
+	hf := fnv.New128a()
+	hf.Write([]byte(key))
+	copy(h[:], hf.Sum(nil))
+	return h
+}
+
+// keyLocation is the file number and the 0-based index of the record that
+// used a key first
+type keyLocation struct {
+	file  uint32
+	index uint64
+}
+
+// keyEntrySize is the size of a spilled key: hash, file number and index
+const keyEntrySize = 16 + 4 + 8
+
+// maxKeyRuns is the number of spilled runs past which they are merged into one
+const maxKeyRuns = 8
+
+// keyIndex remembers the unique keys seen, by hash, with the location of
+// their first record. Past the memory limit the keys spill to disk as sorted
+// runs, each with a bloom filter so only the probable duplicates are looked
+// up on disk. Past maxKeyRuns the runs are merged into one, so the open files
+// stay bounded. It is safe for concurrent use.
+type keyIndex struct {
+	mu    sync.Mutex
+	mem   map[keyHash]keyLocation
+	limit int
+	dir   string
+	runs  []*keyRun
+	seq   int
+	files []string
+}
+
+// keyRun is a spilled file of keys sorted by hash
+type keyRun struct {
+	f     *os.File
+	count int
+	bloom bloomFilter
+}
+
+func newKeyIndex(limit int) *keyIndex {
+	if limit < 1 {
+		limit = DefaultUniqueKeyMemoryLimit
+	}
+	return &keyIndex{mem: map[keyHash]keyLocation{}, limit: limit}
+}
+
+// addFile numbers the file whose keys are added
+func (ki *keyIndex) addFile(name string) uint32 {
+	ki.mu.Lock()
+	defer ki.mu.Unlock()
+	ki.files = append(ki.files, name)
+	return uint32(len(ki.files) - 1)
+}
+
+// fileName returns the name of the numbered file
+func (ki *keyIndex) fileName(file uint32) string {
+	ki.mu.Lock()
+	defer ki.mu.Unlock()
+	return ki.files[file]
+}
+
+// add records the key at the location unless it was seen, returning the
+// location of its first record then
+func (ki *keyIndex) add(h keyHash, loc keyLocation) (first keyLocation, seen bool, err error) {
+	ki.mu.Lock()
+	defer ki.mu.Unlock()
+
+	if first, ok := ki.mem[h]; ok {
+		return first, true, nil
+	}
+	for _, run := range ki.runs {
+		if !run.bloom.has(h) {
+			continue
+		}
+		first, ok, err := run.find(h)
+		if err != nil {
+			return keyLocation{}, false, err
+		}
+		if ok {
+			return first, true, nil
+		}
+	}
+
+	ki.mem[h] = loc
+	if len(ki.mem) >= ki.limit {
+		if err := ki.spill(); err != nil {
+			return keyLocation{}, false, err
+		}
+	}
+	return loc, false, nil
+}
+
+// spill writes the keys in memory into a new sorted run
+func (ki *keyIndex) spill() (err error) {
+	hashes := make([]keyHash, 0, len(ki.mem))
+	for h := range ki.mem {
+		hashes = append(hashes, h)
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i][:], hashes[j][:]) < 0
+	})
+
+	rw, err := ki.newRunWriter(len(hashes))
+	if err != nil {
+		return err
+	}
+	entry := make([]byte, keyEntrySize)
+	for _, h := range hashes {
+		loc := ki.mem[h]
+		copy(entry, h[:])
+		binary.LittleEndian.PutUint32(entry[16:], loc.file)
+		binary.LittleEndian.PutUint64(entry[20:], loc.index)
+		if err := rw.write(entry); err != nil {
+			rw.abort()
+			return err
+		}
+	}
+	run, err := rw.finish()
+	if err != nil {
+		return err
+	}
+
+	ki.runs = append(ki.runs, run)
+	ki.mem = map[keyHash]keyLocation{}
+	if len(ki.runs) > maxKeyRuns {
+		return ki.compact()
+	}
+	return nil
+}
+
+// compact merges the sorted runs into a single one
+func (ki *keyIndex) compact() (err error) {
+	count := 0
+	for _, run := range ki.runs {
+		count += run.count
+	}
+	rw, err := ki.newRunWriter(count)
+	if err != nil {
+		return err
+	}
+
+	// k-way merge of the runs, by the hash of their head entry
+	heads := make(runHeads, 0, len(ki.runs))
+	for _, run := range ki.runs {
+		head := &runHead{
+			r:     bufio.NewReader(io.NewSectionReader(run.f, 0, int64(run.count)*keyEntrySize)),
+			entry: make([]byte, keyEntrySize),
+		}
+		if _, err := io.ReadFull(head.r, head.entry); err != nil {
+			rw.abort()
+			return fmt.Errorf("cannot read the spilled unique keys: %v", err)
+		}
+		heads = append(heads, head)
+	}
+	heap.Init(&heads)
+	for len(heads) > 0 {
+		head := heads[0]
+		if err := rw.write(head.entry); err != nil {
+			rw.abort()
+			return err
+		}
+		_, err := io.ReadFull(head.r, head.entry)
+		switch {
+		case err == io.EOF:
+			heap.Pop(&heads)
+		case err != nil:
+			rw.abort()
+			return fmt.Errorf("cannot read the spilled unique keys: %v", err)
+		default:
+			heap.Fix(&heads, 0)
+		}
+	}
+	merged, err := rw.finish()
+	if err != nil {
+		return err
+	}
+
+	for _, run := range ki.runs {
+		run.remove()
+	}
+	ki.runs = []*keyRun{merged}
+	return nil
+}
+
+// runWriter writes the entries of a new run, in the order of their hash
+type runWriter struct {
+	run *keyRun
+	w   *bufio.Writer
+}
+
+func (ki *keyIndex) newRunWriter(count int) (rw *runWriter, err error) {
+	if ki.dir == "" {
+		ki.dir, err = ioutil.TempDir("", "henqa-keys-")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := os.Create(filepath.Join(ki.dir, fmt.Sprintf("run-%d", ki.seq)))
+	if err != nil {
+		return nil, err
+	}
+	ki.seq++
+	run := &keyRun{f: f, count: count, bloom: newBloomFilter(count)}
+	return &runWriter{run: run, w: bufio.NewWriter(f)}, nil
+}
+
+func (rw *runWriter) write(entry []byte) (err error) {
+	if _, err := rw.w.Write(entry); err != nil {
+		return fmt.Errorf("cannot spill the unique keys: %v", err)
+	}
+	var h keyHash
+	copy(h[:], entry[:16])
+	rw.run.bloom.add(h)
+	return nil
+}
+
+func (rw *runWriter) finish() (run *keyRun, err error) {
+	if err := rw.w.Flush(); err != nil {
+		rw.abort()
+		return nil, fmt.Errorf("cannot spill the unique keys: %v", err)
+	}
+	return rw.run, nil
+}
+
+func (rw *runWriter) abort() {
+	rw.run.remove()
+}
+
+// remove closes and deletes the file of the run
+func (run *keyRun) remove() {
+	run.f.Close()
+	os.Remove(run.f.Name())
+}
+
+// runHead is the current entry of a run being merged
+type runHead struct {
+	r     *bufio.Reader
+	entry []byte
+}
+
+// runHeads is a min-heap of the runs being merged, by the hash of their entry
+type runHeads []*runHead
+
+func (h runHeads) Len() int           { return len(h) }
+func (h runHeads) Less(i, j int) bool { return bytes.Compare(h[i].entry[:16], h[j].entry[:16]) < 0 }
+func (h runHeads) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *runHeads) Push(x interface{}) {
+	*h = append(*h, x.(*runHead))
+}
+
+func (h *runHeads) Pop() interface{} {
+	old := *h
+	head := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return head
+}
+
+// find binary searches the key in the run
+func (run *keyRun) find(h keyHash) (loc keyLocation, ok bool, err error) {
+	entry := make([]byte, keyEntrySize)
+	i := sort.Search(run.count, func(i int) bool {
+		if err != nil {
+			return true
+		}
+		if _, err = run.f.ReadAt(entry, int64(i)*keyEntrySize); err != nil {
+			return true
+		}
+		return bytes.Compare(entry[:16], h[:]) >= 0
+	})
+	if err != nil {
+		return keyLocation{}, false, fmt.Errorf("cannot read the spilled unique keys: %v", err)
+	}
+	if i >= run.count {
+		return keyLocation{}, false, nil
+	}
+	if _, err := run.f.ReadAt(entry, int64(i)*keyEntrySize); err != nil {
+		return keyLocation{}, false, fmt.Errorf("cannot read the spilled unique keys: %v", err)
+	}
+	if !bytes.Equal(entry[:16], h[:]) {
+		return keyLocation{}, false, nil
+	}
+	loc.file = binary.LittleEndian.Uint32(entry[16:])
+	loc.index = binary.LittleEndian.Uint64(entry[20:])
+	return loc, true, nil
+}
+
+// Close removes the spilled keys
+func (ki *keyIndex) Close() (err error) {
+	ki.mu.Lock()
+	defer ki.mu.Unlock()
+	for _, run := range ki.runs {
+		run.f.Close()
+	}
+	ki.runs = nil
+	ki.mem = map[keyHash]keyLocation{}
+	if ki.dir != "" {
+		err = os.RemoveAll(ki.dir)
+		ki.dir = ""
+	}
+	return err
+}
+
+// bloomFilter tells whether a key hash may have been added, with about 1%
+// false positives
+type bloomFilter struct {
+	bits []uint64
+	m    uint64
+}
+
+// bloomHashes is the number of bit positions set per key
+const bloomHashes = 7
+
+func newBloomFilter(n int) bloomFilter {
+	m := uint64(n)*10 + 64
+	return bloomFilter{bits: make([]uint64, (m+63)/64), m: m}
+}
+
+func (bf *bloomFilter) positions(h keyHash, fn func(pos uint64) bool) {
+	// the FNV halves of similar keys differ in few bits, so they are mixed first
+	h1 := mix64(binary.LittleEndian.Uint64(h[:8]))
+	h2 := mix64(binary.LittleEndian.Uint64(h[8:])) | 1
+	for i := uint64(0); i < bloomHashes; i++ {
+		if !fn((h1 + i*h2) % bf.m) {
+			return
+		}
+	}
+}
+
+func (bf *bloomFilter) add(h keyHash) {
+	bf.positions(h, func(pos uint64) bool {
+		bf.bits[pos/64] |= 1 << (pos % 64)
+		return true
+	})
+}
+
+func (bf *bloomFilter) has(h keyHash) (ok bool) {
+	ok = true
+	bf.positions(h, func(pos uint64) bool {
+		ok = bf.bits[pos/64]&(1<<(pos%64)) != 0
+		return ok
+	})
+	return ok
+}
+
+// uniqueKeyChecker reports the records of a file whose unique key was used
+// by an earlier record, of the file or of the files sharing the index
+type uniqueKeyChecker struct {
+	index *keyIndex
+	keys  []string
+	field string
+	file  uint32
+	next  uint64
+}
+
+func newUniqueKeyChecker(index *keyIndex, keys []string, reportKey string) *uniqueKeyChecker {
+	return &uniqueKeyChecker{
+		index: index,
+		keys:  keys,
+		field: strings.Join(keys, ","),
+		file:  index.addFile(reportKey),
+	}
+}
+
+// check adds a duplicate_key error to the records whose key was seen, with
+// the index of the earlier record and its file when it is another one.
+// Records without a value for every key field aren't checked.
+func (uc *uniqueKeyChecker) check(recs []records.RecordGetSetterWithError) (err error) {
+	for _, rec := range recs {
+		index := uc.next
+		uc.next++
+
+		key := recordKey(rec, rec.GetCollection(), uc.keys)
+		if key == "" {
+			continue
+		}
+		first, seen, err := uc.index.add(hashKey(key), keyLocation{file: uc.file, index: index})
+		if err != nil {
+			return err
+		}
+		if !seen {
+			continue
+		}
+
+		value := map[string]interface{}{
+			"key":                recordKeyValue(key),
+			"duplicate_of_index": first.index,
+		}
+		if first.file != uc.file {
+			value["duplicate_of_file"] = uc.index.fileName(first.file)
+		}
+		rec.AddErrors(records.SchemaError{
+			Field:       uc.field,
+			ErrorType:   ErrorTypeDuplicateKey,
+			Description: "An earlier record has the same unique key",
+			Value:       value,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package qa
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/DataHenHQ/datahen/records"
+)
+
+func TestRecordKey(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b map[string]interface{}
+		same bool
+	}{
+		{"string and number", map[string]interface{}{"id": "1"}, map[string]interface{}{"id": 1.0}, false},
+		{"string and bool", map[string]interface{}{"id": "true"}, map[string]interface{}{"id": true}, false},
+		{"json.Number and float64", map[string]interface{}{"id": json.Number("1")}, map[string]interface{}{"id": 1.0}, true},
+		{"json.Number exponent", map[string]interface{}{"id": json.Number("1e2")}, map[string]interface{}{"id": int64(100)}, true},
+		{"fractions", map[string]interface{}{"id": json.Number("1.50")}, map[string]interface{}{"id": 1.5}, true},
+		{"large integers", map[string]interface{}{"id": json.Number("9007199254740993")}, map[string]interface{}{"id": json.Number("9007199254740992")}, false},
+		{"separator in values", map[string]interface{}{"id": "a\x1fb", "n": "c"}, map[string]interface{}{"id": "a", "n": "b\x1fc"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys := []string{"id"}
+			if _, ok := tt.a["n"]; ok {
+				keys = append(keys, "n")
+			}
+			a := recordKey(newMapRecord(tt.a), "", keys)
+			b := recordKey(newMapRecord(tt.b), "", keys)
+			if a == "" || b == "" {
+				t.Fatalf("got empty keys %q and %q", a, b)
+			}
+			if (a == b) != tt.same {
+				t.Errorf("got keys %q and %q, want same %v", a, b, tt.same)
+			}
+		})
+	}
+}
+
+func TestRecordKeyValue(t *testing.T) {
+	rec := newMapRecord(map[string]interface{}{"id": json.Number("7"), "sku": "7", "missing": ""})
+	rec.SetCollection("products")
+
+	key := recordKey(rec, rec.GetCollection(), []string{"id", "sku"})
+	want := []interface{}{json.Number("7"), "7"}
+	if got := recordKeyValue(key); !reflect.DeepEqual(got, want) {
+		t.Errorf("got key value %#v, want %#v", got, want)
+	}
+
+	if key := recordKey(rec, "", []string{"id", "missing"}); key != "" {
+		t.Errorf("got key %q with an empty key field", key)
+	}
+	if key := recordKey(rec, "", []string{"id", "other"}); key != "" {
+		t.Errorf("got key %q with a missing key field", key)
+	}
+}
+
+func TestKeyIndexSpillAndCompact(t *testing.T) {
+	ki := newKeyIndex(4)
+	defer ki.Close()
+	files := []uint32{ki.addFile("a.json"), ki.addFile("b.json")}
+
+	// enough keys for the spilled runs to be merged several times
+	n := 4 * maxKeyRuns * 5
+	for i := 0; i < n; i++ {
+		loc := keyLocation{file: files[i%2], index: uint64(i)}
+		if _, seen, err := ki.add(hashKey(fmt.Sprint(i)), loc); err != nil || seen {
+			t.Fatalf("got key %v seen %v, error %v", i, seen, err)
+		}
+		if len(ki.runs) > maxKeyRuns {
+			t.Fatalf("got %v runs, want at most %v", len(ki.runs), maxKeyRuns)
+		}
+	}
+	if ki.seq <= maxKeyRuns+1 {
+		t.Fatalf("got %v runs written, want the runs merged", ki.seq)
+	}
+	entries, err := ioutil.ReadDir(ki.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(ki.runs) {
+		t.Errorf("got %v spilled files for %v runs", len(entries), len(ki.runs))
+	}
+
+	// every key is found with its first location, in memory or in a run
+	for i := 0; i < n; i++ {
+		first, seen, err := ki.add(hashKey(fmt.Sprint(i)), keyLocation{file: files[1], index: uint64(n + i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := keyLocation{file: files[i%2], index: uint64(i)}
+		if !seen || first != want {
+			t.Errorf("got key %v seen %v at %v, want %v", i, seen, first, want)
+		}
+	}
+	if _, seen, err := ki.add(hashKey("new"), keyLocation{}); err != nil || seen {
+		t.Errorf("got a new key seen %v, error %v", seen, err)
+	}
+
+	dir := ki.dir
+	if err := ki.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadDir(dir); err == nil {
+		t.Errorf("got the spilled keys kept in %v", dir)
+	}
+}
+
+func TestKeyRunFind(t *testing.T) {
+	ki := newKeyIndex(100)
+	defer ki.Close()
+	for i := 0; i < 50; i++ {
+		ki.mem[hashKey(fmt.Sprint(i))] = keyLocation{index: uint64(i)}
+	}
+	if err := ki.spill(); err != nil {
+		t.Fatal(err)
+	}
+	if len(ki.runs) != 1 || ki.runs[0].count != 50 || len(ki.mem) != 0 {
+		t.Fatalf("got %v runs and %v keys in memory after a spill", len(ki.runs), len(ki.mem))
+	}
+
+	run := ki.runs[0]
+	for i := 0; i < 50; i++ {
+		loc, ok, err := run.find(hashKey(fmt.Sprint(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok || loc.index != uint64(i) {
+			t.Errorf("got key %v found %v at %v", i, ok, loc.index)
+		}
+	}
+	if _, ok, err := run.find(hashKey("50")); err != nil || ok {
+		t.Errorf("got a missing key found %v, error %v", ok, err)
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	n := 10000
+	bf := newBloomFilter(n)
+	for i := 0; i < n; i++ {
+		bf.add(hashKey(fmt.Sprint(i)))
+	}
+	for i := 0; i < n; i++ {
+		if !bf.has(hashKey(fmt.Sprint(i))) {
+			t.Fatalf("got key %v missing from the filter", i)
+		}
+	}
+
+	positives := 0
+	for i := n; i < 2*n; i++ {
+		if bf.has(hashKey(fmt.Sprint(i))) {
+			positives++
+		}
+	}
+	if rate := float64(positives) / float64(n); rate > 0.03 {
+		t.Errorf("got %.2f%% false positives, want about 1%%", rate*100)
+	}
+}
+
+func TestUniqueKeyCheckerAcrossFiles(t *testing.T) {
+	ki := newKeyIndex(2)
+	defer ki.Close()
+
+	check := func(reportKey string, data ...map[string]interface{}) []*mapRecord {
+		t.Helper()
+		uc := newUniqueKeyChecker(ki, []string{"id"}, reportKey)
+		recs := make([]records.RecordGetSetterWithError, len(data))
+		mrecs := make([]*mapRecord, len(data))
+		for i, d := range data {
+			mrecs[i] = newMapRecord(d)
+			recs[i] = mrecs[i]
+		}
+		if err := uc.check(recs); err != nil {
+			t.Fatal(err)
+		}
+		return mrecs
+	}
+
+	check("a.json",
+		map[string]interface{}{"id": json.Number("1")},
+		map[string]interface{}{"id": "1"},
+		map[string]interface{}{"id": json.Number("2")},
+		map[string]interface{}{"id": json.Number("3")},
+	)
+	recs := check("b.csv",
+		map[string]interface{}{"id": "1"},
+		map[string]interface{}{"id": "4"},
+		map[string]interface{}{"id": "4"},
+		map[string]interface{}{},
+	)
+
+	want := []map[string]interface{}{
+		{"key": "1", "duplicate_of_index": uint64(1), "duplicate_of_file": "a.json"},
+		nil,
+		{"key": "4", "duplicate_of_index": uint64(1)},
+		nil,
+	}
+	for i, rec := range recs {
+		errs := rec.GetErrors()
+		if want[i] == nil {
+			if len(errs) > 0 {
+				t.Errorf("got errors %v for record %v", errs, i)
+			}
+			continue
+		}
+		if len(errs) != 1 || errs[0].ErrorType != ErrorTypeDuplicateKey {
+			t.Errorf("got errors %v for record %v, want a duplicate_key error", errs, i)
+			continue
+		}
+		if !reflect.DeepEqual(errs[0].Value, want[i]) {
+			t.Errorf("got duplicate %v for record %v, want %v", errs[0].Value, i, want[i])
+		}
+	}
+}
@@ -1,5 +1,11 @@
 package qa
 
+import (
+	"sort"
+
+	"github.com/DataHenHQ/henqa_shared/customtypes"
+)
+
 func uniqueStringSlice(stringSlice []string) []string {
 	keys := make(map[string]bool)
 	list := []string{}
@@ -11,3 +17,45 @@ func uniqueStringSlice(stringSlice []string) []string {
 	}
 	return list
 }
+
+func sortedErrKeys(errStats map[string]*customtypes.ErrorStat) []string {
+	keys := make([]string, 0, len(errStats))
+	for k := range errStats {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// copyVars returns a deep copy of the workflow variables, so the nested maps
+// and slices of a file's variables are not shared with other files
+func copyVars(vars map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(vars))
+	for k, v := range vars {
+		c[k] = copyValue(v)
+	}
+	return c
+}
+
+// copyValue deep copies the maps and slices of the value, as decoded from JSON
+func copyValue(value interface{}) interface{} {
+	switch val := value.(type) {
+	case map[string]interface{}:
+		return copyVars(val)
+	case []interface{}:
+		c := make([]interface{}, len(val))
+		for i, item := range val {
+			c[i] = copyValue(item)
+		}
+		return c
+	case map[string]string:
+		c := make(map[string]string, len(val))
+		for k, v := range val {
+			c[k] = v
+		}
+		return c
+	case []string:
+		return append([]string{}, val...)
+	}
+	return value
+}
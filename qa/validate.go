@@ -1,19 +1,23 @@
 package qa
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 
 	yaml "github.com/ghodss/yaml"
 
 	"github.com/DataHenHQ/datahen/records"
 	"github.com/DataHenHQ/henqa_shared/customtypes"
 	workflows "github.com/DataHenHQ/henqa_workflows"
-	jsonpatch "github.com/evanphx/json-patch/v5"
 	"github.com/xeipuuv/gojsonschema"
 )
 
@@ -24,87 +28,177 @@ type RecordWrapper struct {
 	Record interface{}           `json:"record"`
 }
 
-type RecordsValidationResult struct {
-	Collections map[string][]RecordWrapper         `json:"collections"`
-	Stats       map[string]*records.CollectionStat `json:"stats"`
+// CollectionErrorStats keeps the error stats of a single file by collection name.
+type CollectionErrorStats map[string]customtypes.ErrorStats
+
+// ValidationResult keeps the outcome of a validation run keyed by file.
+// Complete is false when the run was stopped before validating every file.
+// Skipped are the files that could not be validated, such as the unsupported files named as inputs,
+// and Missing are the inputs that don't exist or cannot be read.
+type ValidationResult struct {
+	Files    map[string]*FileResult `json:"files"`
+	Skipped  []string               `json:"skipped,omitempty"`
+	Missing  []string               `json:"missing,omitempty"`
+	Complete bool                   `json:"complete"`
+	// Baseline compares the summary against the baseline reports, if any
+	Baseline *SummaryDiff `json:"baseline,omitempty"`
+
+	mu sync.Mutex
 }
 
-var gvars = make(map[string]interface{})
+// FileResult keeps the outcome of validating a single file.
+type FileResult struct {
+	File                 string                             `json:"file"`
+	RecordCount          uint64                             `json:"record_count"`
+	ErrorStats           customtypes.ErrorStats             `json:"error_stats"`
+	CollectionErrorStats CollectionErrorStats               `json:"collection_error_stats,omitempty"`
+	CollectionStats      map[string]*records.CollectionStat `json:"collection_stats,omitempty"`
+	Profile              *FileProfile                       `json:"profile,omitempty"`
+	Incomplete           bool                               `json:"incomplete,omitempty"`
+}
 
-func Validate(ins []string, schemas []string, wfname string, outDir string, summaryFile string, batchSize int, maxRecsWithErrors int) (err error) {
+// validationStatus is saved next to the overall summary to tell whether it is complete
+type validationStatus struct {
+	Complete    bool   `json:"complete"`
+	Error       string `json:"error,omitempty"`
+	FileCount   int    `json:"file_count"`
+	RecordCount uint64 `json:"record_count"`
+	// FileRecordCounts are the records validated in each file, by report key
+	FileRecordCounts map[string]uint64 `json:"file_record_counts,omitempty"`
+}
 
-	if len(schemas) == 0 && wfname == "" {
-		msg := "you need to specify either a schema or a workflow or both"
-		fmt.Println(msg)
-		fmt.Println("aborting validation.")
-		return errors.New(msg)
+// RecordCount returns the number of records validated across all files.
+func (r *ValidationResult) RecordCount() (count uint64) {
+	for _, fr := range r.Files {
+		count += fr.RecordCount
 	}
+	return count
+}
 
-	files := getListOfFiles(ins)
-	// if len(files) > 0 {
-	// 	fmt.Println("input files are:")
-	// }
-	// for _, f := range files {
-	// 	fmt.Println(f)
-	// }
+func (r *ValidationResult) addFile(key string, fr *FileResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Files[key] = fr
+}
 
-	mergedSchema, err := getAndMergeSchemaFiles(schemas)
-	if err != nil {
-		fmt.Println("gotten error with merging schemas:", err.Error())
-		fmt.Println("aborting validation.")
-		return
-	}
-	// fmt.Println("merged Schema:")
-	// fmt.Println(string(mergedSchema))
+func (r *ValidationResult) addSkipped(f string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Skipped = append(r.Skipped, f)
+}
 
-	// ensure output dir exists
-	err = createOutDirIfNotExist(outDir)
-	if err != nil {
-		fmt.Println("gotten error creating output directory:", err.Error())
-		fmt.Println("aborting validation.")
-		return
+// ErrorStats returns the error stats of every file keyed by file, as saved
+// into the overall summary file.
+func (r *ValidationResult) ErrorStats() map[string]customtypes.ErrorStats {
+	summaryErrStats := map[string]customtypes.ErrorStats{}
+	for file, fr := range r.Files {
+		summaryErrStats[file] = fr.ErrorStats
 	}
+	return summaryErrStats
+}
 
-	// if schema is empty, pass validation of everything by using {}
-	if len(mergedSchema) == 0 {
-		mergedSchema = []byte("{}")
-	}
+// ErrNoSchemaOrWorkflow is returned when a validation has nothing to validate with.
+var ErrNoSchemaOrWorkflow = errors.New("you need to specify a schema, a workflow or a unique key")
 
-	err = validateWithSchema(files, mergedSchema, wfname, outDir, summaryFile, batchSize, maxRecsWithErrors)
-	if err != nil {
-		fmt.Println("gotten error running the validation:", err.Error())
-		fmt.Println("aborting validation.")
-		return
+// ErrNoInputFiles is returned when the inputs hold no file to validate.
+var ErrNoInputFiles = errors.New("no input file to validate")
+
+// InputError is returned along with the result when some inputs are missing,
+// cannot be read or were skipped, so a run never passes without validating them.
+type InputError struct {
+	Missing []string
+	Skipped []string
+}
+
+func (e *InputError) Error() string {
+	msgs := []string{}
+	if len(e.Missing) > 0 {
+		msgs = append(msgs, fmt.Sprintf("missing or unreadable inputs: %v", strings.Join(e.Missing, ", ")))
 	}
+	if len(e.Skipped) > 0 {
+		msgs = append(msgs, fmt.Sprintf("skipped inputs: %v", strings.Join(e.Skipped, ", ")))
+	}
+	return strings.Join(msgs, "; ")
+}
 
-	fmt.Println("Done validating record. The report folder would be located at", outDir)
-	return nil
+// Validate validates the input files and directories and writes the reports into outDir.
+// See Validator for collection schemas, the structured result and the other options.
+func Validate(ins []string, schemas []string, wfname string, outDir string, summaryFile string, batchSize int, maxRecsWithErrors int) (err error) {
+	v := NewValidator(Options{
+		Inputs:            ins,
+		Schemas:           schemas,
+		Workflow:          wfname,
+		OutDir:            outDir,
+		SummaryFile:       summaryFile,
+		BatchSize:         batchSize,
+		MaxRecsWithErrors: &maxRecsWithErrors,
+	})
+	_, err = v.Run(context.Background())
+	return err
 }
 
-func getListOfFiles(ins []string) (files []string) {
+// getListOfFiles lists the files to validate from the input files, directories
+// and archives. The inputs that don't exist or cannot be read are returned as missing.
+// The files named by the inputs are always listed, so the unsupported ones fail
+// the run, while the unsupported files of directories and archives are left out.
+func (v *Validator) getListOfFiles(ins []string) (files []string, missing []string) {
 	for _, in := range ins {
 
+		if in == StdinInput {
+			files = append(files, in)
+			continue
+		}
+
 		if isDir(in) {
-			subDirFiles := getFilesFromDir(in)
+			subDirFiles, unreadable := v.getFilesFromDir(in)
 			files = append(files, subDirFiles...)
+			missing = append(missing, unreadable...)
 			continue
 		}
 
 		if !fileExists(in) {
-			fmt.Printf("file does not exist: %v\n", in)
+			v.logf("file does not exist: %v\n", in)
+			missing = append(missing, in)
+			continue
+		}
+		v.logln("file exists:", in)
+
+		if archiveFormat(in) != archiveNone {
+			members, err := v.getFilesFromArchive(in)
+			if err != nil {
+				missing = append(missing, in)
+			}
+			files = append(files, members...)
 			continue
 		}
-		fmt.Println("file exists:", in)
 
 		files = append(files, in)
 	}
 
-	return uniqueStringSlice(files)
+	return uniqueStringSlice(files), uniqueStringSlice(missing)
+}
+
+// inputExtensions are the extensions of the files that can be validated,
+// compressed or not
+var inputExtensions = map[string]bool{
+	".csv":     true,
+	".tsv":     true,
+	".json":    true,
+	".njson":   true,
+	".ndjson":  true,
+	".parquet": true,
+	".xlsx":    true,
+}
+
+// isInputFile tells whether the file found in a directory or an archive can
+// be validated, by the extension of its logical name
+func isInputFile(f string) bool {
+	return inputExtensions[strings.ToLower(filepath.Ext(logicalName(f)))]
 }
 
 func fileExists(filename string) bool {
 	info, err := os.Stat(filename)
-	if os.IsNotExist(err) {
+	if err != nil {
 		return false
 	}
 	return !info.IsDir()
@@ -112,31 +206,17 @@ func fileExists(filename string) bool {
 
 func isDir(filename string) bool {
 	info, err := os.Stat(filename)
-	if os.IsNotExist(err) {
+	if err != nil {
 		return false
 	}
 	return info.IsDir()
 }
 
-func getFilesFromDir(dir string) (files []string) {
-	fs, _ := ioutil.ReadDir(dir)
-	for _, f := range fs {
-		subPath := filepath.Join(dir, f.Name())
-		if f.IsDir() {
-			subFiles := getFilesFromDir(subPath)
-			for _, sf := range subFiles {
-				files = append(files, sf)
-			}
-			continue
-		}
-
-		files = append(files, filepath.Join(dir, f.Name()))
-
-	}
-	return files
-}
-
-func getAndMergeSchemaFiles(files []string) (schema []byte, err error) {
+func (v *Validator) getAndMergeSchemaFiles(files []string, reg *schemaRegistry) (schema []byte, err error) {
+	var (
+		mergedFiles []string
+		schemas     [][]byte
+	)
 
 	for _, f := range files {
 
@@ -150,91 +230,313 @@ func getAndMergeSchemaFiles(files []string) (schema []byte, err error) {
 		case ".yaml", ".yml":
 			nj, err := yaml.YAMLToJSON(nschema)
 			if err != nil {
-				fmt.Printf("error converting YAML to JSON: %v\n", err)
+				v.logf("error converting YAML to JSON: %v\n", err)
 				continue
 			}
 			nschema = nj
 		}
 
-		// if the first schema, then simply assign it
-		if schema == nil {
-			schema = nschema
-			continue
+		// bundle the local schemas referenced by $ref
+		nschema, err = bundleSchemaRefs(reg, nschema, f)
+		if err != nil {
+			v.logf("cannot resolve the $ref of schema %v: %v\n", f, err.Error())
+			return nil, err
 		}
 
-		newSchema, err := jsonpatch.MergeMergePatches(schema, nschema)
+		mergedFiles = append(mergedFiles, f)
+		schemas = append(schemas, nschema)
+	}
+
+	// combine the schemas with the merge strategy
+	schema, err = mergeSchemas(v.opts.MergeStrategy, mergedFiles, schemas)
+	if err != nil {
+		v.logf("cannot merge schema: %v\n", err.Error())
+		return nil, err
+	}
+	return schema, nil
+}
+
+// ParseCollectionSchemas builds the collection to schema files mapping from
+// "collection=schema.json" pairs and an optional JSON or YAML mapping file
+// such as {"products": "products.json", "reviews": ["base.json", "reviews.json"]}.
+// Schema paths in the mapping file are relative to the mapping file, schema
+// URLs are kept as they are.
+func ParseCollectionSchemas(pairs []string, mappingFile string) (colSchemas map[string][]string, err error) {
+	colSchemas = map[string][]string{}
+
+	if mappingFile != "" {
+		data, err := readFile(mappingFile)
 		if err != nil {
-			fmt.Print("cannot merge schema:", err.Error())
 			return nil, err
 		}
+		switch filepath.Ext(mappingFile) {
+		case ".yaml", ".yml":
+			data, err = yaml.YAMLToJSON(data)
+			if err != nil {
+				return nil, fmt.Errorf("error converting YAML to JSON: %v", err)
+			}
+		}
 
-		schema = newSchema
+		mapping := map[string]interface{}{}
+		if err := json.Unmarshal(data, &mapping); err != nil {
+			return nil, fmt.Errorf("invalid collection schema mapping file %v: %v", mappingFile, err)
+		}
+
+		baseDir := filepath.Dir(mappingFile)
+		for col, v := range mapping {
+			var files []string
+			switch val := v.(type) {
+			case string:
+				files = []string{val}
+			case []interface{}:
+				for _, item := range val {
+					sf, ok := item.(string)
+					if !ok {
+						return nil, fmt.Errorf("invalid schema file %v for collection %v", item, col)
+					}
+					files = append(files, sf)
+				}
+			default:
+				return nil, fmt.Errorf("invalid schema files %v for collection %v", v, col)
+			}
 
+			for _, sf := range files {
+				if !filepath.IsAbs(sf) && !isRemoteSchema(sf) {
+					sf = filepath.Join(baseDir, sf)
+				}
+				colSchemas[col] = append(colSchemas[col], sf)
+			}
+		}
 	}
-	return schema, nil
+
+	for _, pair := range pairs {
+		i := strings.Index(pair, "=")
+		if i < 1 || i == len(pair)-1 {
+			return nil, fmt.Errorf("invalid collection schema %q, expected collection=schema.json", pair)
+		}
+		col := pair[:i]
+		colSchemas[col] = append(colSchemas[col], pair[i+1:])
+	}
+
+	return colSchemas, nil
 }
 
-func validateWithSchema(files []string, schema []byte, wfname string, outDir string, summaryFile string, batchSize int, maxRecsWithErrors int) (err error) {
-	// load schema
-	schemaString := string(schema)
-	schemaSl := gojsonschema.NewStringLoader(schemaString)
-	sl := gojsonschema.NewSchemaLoader()
-	sl.Validate = true
-	err = sl.AddSchemas(schemaSl)
+func (v *Validator) validateWithSchema(ctx context.Context, files []string, schema []byte, colSchemas map[string][]byte) (result *ValidationResult, err error) {
+	outDir := v.opts.OutDir
+
+	// build default schema
+	loader, err := loadSchema(schema)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	// build schema
-	loader := gojsonschema.NewStringLoader(schemaString)
 	colSchemaLoaders := make(map[string]*gojsonschema.JSONLoader)
-	colSchemaLoaders["default"] = &loader
+	colSchemaLoaders["default"] = loader
+
+	// build collection schemas
+	for col, colSchema := range colSchemas {
+		colLoader, err := loadSchema(colSchema)
+		if err != nil {
+			v.logf("gotten error loading schema of collection %v: %v\n", col, err.Error())
+			return nil, err
+		}
+		colSchemaLoaders[col] = colLoader
+	}
+
+	// CSV values are converted to their schema types in coerce mode, the
+	// types are read once for every file of the run
+	var coercer *schemaCoercer
+	if v.opts.Coerce {
+		coercer, err = newSchemaCoercer(colSchemaLoaders, v.opts.CoerceArraySeparator)
+		if err != nil {
+			v.logln("gotten error reading the schema types:", err.Error())
+			return nil, err
+		}
+	}
 
 	// load workflow
-	wf, err := workflows.GetWorkflow(wfname)
+	wf, err := workflows.GetWorkflow(v.opts.Workflow)
 	if err != nil {
-		fmt.Println(err.Error())
-		return err
+		v.logln(err.Error())
+		return nil, err
 	}
 
-	// loop each file to validate
-	summaryErrStats := map[string]customtypes.ErrorStats{}
-	for _, f := range files {
-		// validate file
-		shouldContinue, err := validateSingleFile(f, colSchemaLoaders, wf, summaryErrStats, batchSize, outDir, maxRecsWithErrors, wfname)
-		if err != nil {
-			if shouldContinue {
-				continue
+	// gvars kept across files needs the files to be validated in order
+	parallel := v.opts.Parallel
+	if parallel > 1 && wf != nil && wf.KeepGvars {
+		v.logln("workflow", v.opts.Workflow, "keeps gvars across files, validating files sequentially")
+		parallel = 1
+	}
+
+	// workflow variables are scoped to this run, seeded from the options
+	runGvars := copyVars(v.opts.Vars)
+
+	// unique keys checked across files share the keys of the run
+	var runKeys *keyIndex
+	if len(v.opts.UniqueKey) > 0 && v.opts.UniqueKeyAcrossFiles {
+		runKeys = newKeyIndex(v.opts.UniqueKeyMemoryLimit)
+		defer runKeys.Close()
+	}
+
+	// the tar archives are kept open while their members are validated
+	defer v.tars.Close()
+
+	// reports are keyed by the relative path of each file to avoid collisions
+	keys := reportKeys(v.opts.Inputs, files)
+	if _, ok := keys[StdinInput]; ok {
+		keys[StdinInput] = v.opts.StdinName
+	}
+
+	// validate the files using a pool of workers, stopping early when the
+	// context is done or a file fails
+	result = &ValidationResult{Files: map[string]*FileResult{}}
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		errMu    sync.Mutex
+		firstErr error
+	)
+	fileCh := make(chan string)
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range fileCh {
+				// the feed can race with the cancellation, leave the files not started yet
+				if runCtx.Err() != nil {
+					continue
+				}
+				shouldContinue, err := v.validateSingleFile(runCtx, f, keys[f], colSchemaLoaders, coercer, wf, runGvars, runKeys, result)
+				if err == nil {
+					continue
+				}
+				if shouldContinue {
+					result.addSkipped(f)
+					continue
+				}
+				errMu.Lock()
+				if firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				errMu.Unlock()
 			}
-			return err
+		}()
+	}
+feed:
+	for _, f := range files {
+		select {
+		case fileCh <- f:
+		case <-runCtx.Done():
+			break feed
 		}
 	}
+	close(fileCh)
+	wg.Wait()
+
+	runErr := ctx.Err()
+	if runErr == nil && firstErr != nil {
+		return nil, firstErr
+	}
+	result.Complete = runErr == nil
+	sort.Strings(result.Skipped)
 
 	// write overrall summary
-	if err := writeOverallSummaryFile(outDir, summaryFile, summaryErrStats); err != nil {
-		return err
+	summaryColErrStats := map[string]CollectionErrorStats{}
+	for file, fr := range result.Files {
+		if len(fr.CollectionErrorStats) > 0 {
+			summaryColErrStats[file] = fr.CollectionErrorStats
+		}
+	}
+	if err := writeOverallSummaryFile(outDir, v.opts.SummaryFile, result.ErrorStats()); err != nil {
+		return nil, err
+	}
+	if len(summaryColErrStats) > 0 {
+		if err := writeOverallSummaryFile(outDir, fmt.Sprintf("%v.collections", v.opts.SummaryFile), summaryColErrStats); err != nil {
+			return nil, err
+		}
 	}
 
-	return nil
+	// write the status of the overall summary
+	status := validationStatus{
+		Complete:         result.Complete,
+		FileCount:        len(result.Files),
+		RecordCount:      result.RecordCount(),
+		FileRecordCounts: map[string]uint64{},
+	}
+	for file, fr := range result.Files {
+		status.FileRecordCounts[file] = fr.RecordCount
+	}
+	if runErr != nil {
+		status.Error = runErr.Error()
+	}
+	if err := writeOverallSummaryFile(outDir, fmt.Sprintf("%v.status", v.opts.SummaryFile), status); err != nil {
+		return nil, err
+	}
+
+	return result, runErr
+}
+
+// loadSchema validates the schema and builds a loader for it
+func loadSchema(schema []byte) (loader *gojsonschema.JSONLoader, err error) {
+	schemaString := string(schema)
+	schemaSl := gojsonschema.NewStringLoader(schemaString)
+	sl := gojsonschema.NewSchemaLoader()
+	sl.Validate = true
+	err = sl.AddSchemas(schemaSl)
+	if err != nil {
+		return nil, err
+	}
+
+	l := gojsonschema.NewStringLoader(schemaString)
+	return &l, nil
 }
 
-func validateSingleFile(f string, colSchemaLoaders map[string]*gojsonschema.JSONLoader, wf *workflows.Workflow, summaryErrStats map[string]customtypes.ErrorStats, batchSize int, outDir string, maxRecsWithErrors int, wfname string) (shouldContinue bool, err error) {
+func (v *Validator) validateSingleFile(ctx context.Context, f string, reportKey string, colSchemaLoaders map[string]*gojsonschema.JSONLoader, coercer *schemaCoercer, wf *workflows.Workflow, runGvars map[string]interface{}, runKeys *keyIndex, result *ValidationResult) (shouldContinue bool, err error) {
+	outDir := v.opts.OutDir
+
 	// analyze file extension
-	processFile, includeCollection, err := analyzeFileExtension(f)
+	processFile, includeCollection, err := v.analyzeFileExtension(f, reportKey, coercer)
 	if err != nil {
 		return true, err
 	}
 
+	// unknown collections are mapped to the default schema, use a copy of the
+	// schema loaders since files can be validated at the same time
+	fileSchemaLoaders := make(map[string]*gojsonschema.JSONLoader, len(colSchemaLoaders))
+	for col, l := range colSchemaLoaders {
+		fileSchemaLoaders[col] = l
+	}
+
 	// init detail file
-	err = initDetailFile(outDir, f)
+	dw, err := newDetailWriter(outDir, reportKey, v.opts.DetailsFormat)
 	if err != nil {
-		fmt.Println("gotten error initializing output files for ", f, ":", err.Error())
+		v.logln("gotten error initializing output files for ", f, ":", err.Error())
 		return false, err
 	}
+	defer dw.Close()
 
-	// keep gvars when required accross all files
+	// keep gvars when required accross all files of the run
+	gvars := runGvars
 	if wf == nil || !wf.KeepGvars {
-		gvars = make(map[string]interface{})
+		gvars = copyVars(v.opts.Vars)
+	}
+
+	// check the unique key within the file unless checked across files
+	var uc *uniqueKeyChecker
+	if len(v.opts.UniqueKey) > 0 {
+		fileKeys := runKeys
+		if fileKeys == nil {
+			fileKeys = newKeyIndex(v.opts.UniqueKeyMemoryLimit)
+			defer fileKeys.Close()
+		}
+		uc = newUniqueKeyChecker(fileKeys, v.opts.UniqueKey, reportKey)
+	}
+
+	// profile the fields in the same pass
+	var rp *recordProfiler
+	if v.opts.Profile {
+		rp = newRecordProfiler(v.opts.ProfileTopN)
 	}
 
 	// execute workflow for filename validation
@@ -248,28 +550,36 @@ func validateSingleFile(f string, colSchemaLoaders map[string]*gojsonschema.JSON
 
 	// process the files and keep stats
 	errStats := map[string]*customtypes.ErrorStat{}
+	colErrStats := CollectionErrorStats{}
+	colRecordCounts := map[string]uint64{}
+	colstats := make(map[string]*records.CollectionStat)
 	var recordCount uint64 = 0
-	vbf := validateBatchFn(f, colSchemaLoaders, wf, gvars, outDir, includeCollection, &recordCount, errStats, maxRecsWithErrors, file_type)
+	vbf := v.validateBatchFn(ctx, dw, fileSchemaLoaders, wf, gvars, uc, rp, includeCollection, &recordCount, errStats, colErrStats, colRecordCounts, colstats, file_type)
 	var configReader records.ConfigReaderFn = nil
 	if wf != nil {
 		configReader = wf.ExecConfigReader
 	}
 	vprf := validatePreRecordsFn(file_type, wf, gvars, errStats, outDir)
-	err = processFile(f, batchSize, configReader, vbf, vprf)
-	if err != nil {
-		fmt.Println("gotten error processing input file ", f, ":", err.Error())
+	err = processFile(f, v.opts.BatchSize, configReader, vbf, vprf)
+	cancelled := err != nil && ctx.Err() != nil
+	if err != nil && !cancelled {
+		v.logln("gotten error processing input file ", f, ":", err.Error())
 		return false, err
 	}
+	if cancelled {
+		v.logln("")
+		v.logln("stopped validating", f, ":", ctx.Err().Error())
+	}
 
-	// close detail file
-	err = closeDetailFile(outDir, f)
+	// close detail file, also when cancelled so the partial details remain valid
+	err = dw.Close()
 	if err != nil {
-		fmt.Println("gotten error initializing output files for ", f, ":", err.Error())
+		v.logln("gotten error initializing output files for ", f, ":", err.Error())
 		return false, err
 	}
 
-	// execute workflow for summary
-	if wf != nil {
+	// execute workflow for summary, skipped on partial data
+	if wf != nil && !cancelled {
 		err := wf.ExecSummary(file_type, gvars, errStats, outDir, f)
 		if err != nil {
 			return false, err
@@ -281,21 +591,102 @@ func validateSingleFile(f string, colSchemaLoaders map[string]*gojsonschema.JSON
 		es.RecordCount = recordCount
 		es.CalculatePercentage()
 	}
-	writeSummaryOutputs(outDir, f, errStats)
+	writeSummaryOutputs(outDir, reportKey, errStats)
+
+	// fix collection errStats record counters and write collection summary
+	for col, ces := range colErrStats {
+		for _, es := range ces {
+			es.RecordCount = colRecordCounts[col]
+			es.CalculatePercentage()
+		}
+	}
+	if len(colErrStats) > 0 {
+		writeSummaryOutputs(outDir, fmt.Sprintf("%v.collections", reportKey), colErrStats)
+	}
+
+	// write the profile of the fields, partial when cancelled
+	var profile *FileProfile
+	if rp != nil {
+		profile = rp.profile()
+		if err := writeProfile(outDir, reportKey, profile); err != nil {
+			v.logln("gotten error writing the profile of ", f, ":", err.Error())
+			return false, err
+		}
+	}
 
 	// map errors to summary stats file
-	basefile := filepath.Base(f)
-	summaryErrStats[basefile] = errStats
-	fmt.Println("")
+	result.addFile(reportKey, &FileResult{
+		File:                 f,
+		RecordCount:          recordCount,
+		ErrorStats:           errStats,
+		CollectionErrorStats: colErrStats,
+		CollectionStats:      colstats,
+		Profile:              profile,
+		Incomplete:           cancelled,
+	})
+	v.logln("")
+	if cancelled {
+		return false, ctx.Err()
+	}
 	return false, nil
 }
 
-func validateBatchFn(f string, colSchemaLoaders map[string]*gojsonschema.JSONLoader, wf *workflows.Workflow, gvars map[string]interface{}, outDir string, includeCollection bool, recordCount *uint64, errStats map[string]*customtypes.ErrorStat, maxRecsWithErrors int, file_type string) records.ValidateFn {
-	colstats := make(map[string]*records.CollectionStat)
-	isFirst := true
+// ParseVars builds the workflow variables from an optional JSON or YAML file
+// and "key=value" pairs, the pairs override the file and are kept as strings.
+func ParseVars(pairs []string, varsFile string) (vars map[string]interface{}, err error) {
+	vars = map[string]interface{}{}
+
+	if varsFile != "" {
+		data, err := readFile(varsFile)
+		if err != nil {
+			return nil, err
+		}
+		switch filepath.Ext(varsFile) {
+		case ".yaml", ".yml":
+			data, err = yaml.YAMLToJSON(data)
+			if err != nil {
+				return nil, fmt.Errorf("error converting YAML to JSON: %v", err)
+			}
+		}
+		if err := json.Unmarshal(data, &vars); err != nil {
+			return nil, fmt.Errorf("invalid vars file %v: %v", varsFile, err)
+		}
+	}
+
+	for _, pair := range pairs {
+		i := strings.Index(pair, "=")
+		if i < 1 {
+			return nil, fmt.Errorf("invalid var %q, expected key=value", pair)
+		}
+		vars[pair[:i]] = pair[i+1:]
+	}
+
+	return vars, nil
+}
+
+func (v *Validator) validateBatchFn(ctx context.Context, dw *detailWriter, colSchemaLoaders map[string]*gojsonschema.JSONLoader, wf *workflows.Workflow, gvars map[string]interface{}, uc *uniqueKeyChecker, rp *recordProfiler, includeCollection bool, recordCount *uint64, errStats map[string]*customtypes.ErrorStat, colErrStats CollectionErrorStats, colRecordCounts map[string]uint64, colstats map[string]*records.CollectionStat, file_type string) records.ValidateFn {
+	maxRecsWithErrors := v.maxRecsWithErrors
 	return func(recs []records.RecordGetSetterWithError) (err2 error) {
+		// stop processing the file when the context is done
+		if err2 = ctx.Err(); err2 != nil {
+			return err2
+		}
+
+		// report the duplicate unique keys, in the order of the records
+		if uc != nil {
+			if err2 = uc.check(recs); err2 != nil {
+				return err2
+			}
+		}
+
+		// profile the values as read, before any workflow changes them
+		if rp != nil {
+			if err2 = rp.add(recs); err2 != nil {
+				return err2
+			}
+		}
+
 		collection := ""
-		var totalRecWithErrors = 0
 
 		// loop records and assign schema
 		for _, rec := range recs {
@@ -328,15 +719,15 @@ func validateBatchFn(f string, colSchemaLoaders map[string]*gojsonschema.JSONLoa
 
 			// write validation outputs
 			*recordCount += uint64(len(recwes))
-			err2 = writeValidationOutputs(outDir, f, recwes, errStats, isFirst, includeCollection, &totalRecWithErrors, maxRecsWithErrors)
+			err2 = writeValidationOutputs(dw, recwes, errStats, includeCollection, maxRecsWithErrors)
 			if err2 != nil {
 				return err2
 			}
-			if len(errStats) > 0 {
-				isFirst = false
-			}
+
+			// keep collection level stats
+			updateCollectionStats(recwes, colErrStats, colRecordCounts)
 		}
-		fmt.Print(".")
+		v.logf(".")
 
 		return nil
 	}
@@ -351,15 +742,54 @@ func validatePreRecordsFn(file_type string, wf *workflows.Workflow, gvars map[st
 	}
 }
 
-func analyzeFileExtension(f string) (processFile processFileStreamFn, includeCollection bool, err error) {
+func (v *Validator) analyzeFileExtension(f string, reportKey string, coercer *schemaCoercer) (processFile processFileStreamFn, includeCollection bool, err error) {
+	// CSV files are read with the dialect of their report path
+	co, defaultDialect, err := v.csvOptionsFor(reportKey)
+	if err != nil {
+		v.logln("gotten error with the CSV dialect of", f, ":", err.Error())
+		return nil, false, err
+	}
+
+	// CSV values are converted to their schema types in coerce mode
+	if coercer != nil {
+		co.coercer = coercer
+		defaultDialect = false
+	}
+
+	if f == StdinInput {
+		return v.analyzeStdin(co)
+	}
+
+	// archive members are streamed out of the archive
+	if archive, member, ok := splitArchivePath(f); ok {
+		return v.analyzeArchiveMember(f, archive, member, co)
+	}
+
+	// compressed files are streamed through the decompressor
+	codec, name, err := detectCompression(f)
+	if err != nil {
+		v.logln("gotten error reading ", f, ":", err.Error())
+		return nil, false, err
+	}
+	if codec != compressionNone {
+		return v.analyzeCompressedFile(f, name, codec, co)
+	}
+
 	switch filepath.Ext(f) {
 	case ".csv":
-		processFile = records.ProcessCSVFile
+		// the default dialect keeps the datahen reader and its records
+		if defaultDialect {
+			processFile = records.ProcessCSVFile
+		} else {
+			processFile = csvFileProcessor(co)
+		}
+	case ".tsv":
+		processFile = csvFileProcessor(co)
 	case ".json":
 		validJSON, err := records.IsJSON(f)
 		if err != nil {
 			msg := fmt.Sprintf("%s is not a valid json file. Skipping", f)
-			fmt.Println(msg)
+			v.logln(msg)
 			return nil, false, errors.New(msg)
 		}
 		if validJSON {
@@ -368,22 +798,72 @@ func analyzeFileExtension(f string) (processFile processFileStreamFn, includeCol
 			processFile = records.ProcessNJSONFile
 		}
 		includeCollection = true
-	case ".njson":
+	case ".njson", ".ndjson":
 		processFile = records.ProcessNJSONFile
+	case ".parquet":
+		processFile = processParquetFile
+	case ".xlsx":
+		processFile = xlsxFileProcessor(v.xlsxOptions())
+		includeCollection = true
 	default:
-		msg := fmt.Sprintf("%s is not a .csv or .json file. Skipping", f)
-		fmt.Println(msg)
+		msg := fmt.Sprintf("%s is not a .csv, .tsv, .json, .parquet or .xlsx file. Skipping", f)
+		v.logln(msg)
 		return nil, false, errors.New(msg)
 	}
 	if err != nil {
-		fmt.Println("gotten error reading ", f, ":", err.Error())
+		v.logln("gotten error reading ", f, ":", err.Error())
 		return nil, false, err
 	}
-	fmt.Println("validating:", f)
+	v.logln("validating:", f)
 
 	return processFile, includeCollection, nil
 }
 
+// xlsxOptions returns the sheets and header row of the Excel files
+func (v *Validator) xlsxOptions() xlsxOptions {
+	return xlsxOptions{sheets: v.opts.XLSXSheets, headerRow: v.opts.XLSXHeaderRow}
+}
+
+// streamProcessorFor returns the processor of the stream of the file name,
+// spooling the formats that cannot be streamed into a temp file
+func (v *Validator) streamProcessorFor(name string, co *csvOptions) (process processReaderFn, includeCollection bool, ok bool) {
+	if process, includeCollection, ok = readerProcessorFor(name, co); ok {
+		return process, includeCollection, true
+	}
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".parquet":
+		return spoolingProcessor(name, processParquetFile), false, true
+	case ".xlsx":
+		return spoolingProcessor(name, xlsxFileProcessor(v.xlsxOptions())), true, true
+	}
+	return nil, false, false
+}
+
+func (v *Validator) analyzeCompressedFile(f string, name string, codec string, co *csvOptions) (processFile processFileStreamFn, includeCollection bool, err error) {
+	process, includeCollection, ok := v.streamProcessorFor(name, co)
+	if !ok {
+		msg := fmt.Sprintf("%s is not a compressed .csv, .tsv, .json, .parquet or .xlsx file. Skipping", f)
+		v.logln(msg)
+		return nil, false, errors.New(msg)
+	}
+	v.logf("validating: %v (%v)\n", f, codec)
+
+	return decompressingProcessor(codec, process), includeCollection, nil
+}
+
+func (v *Validator) analyzeArchiveMember(f string, archive string, member string, co *csvOptions) (processFile processFileStreamFn, includeCollection bool, err error) {
+	process, includeCollection, ok := v.streamProcessorFor(logicalName(member), co)
+	if !ok {
+		msg := fmt.Sprintf("%s is not a .csv, .tsv, .json, .parquet or .xlsx file. Skipping", f)
+		v.logln(msg)
+		return nil, false, errors.New(msg)
+	}
+	v.logln("validating:", f)
+
+	return archiveMemberProcessor(&v.tars, archive, member, process), includeCollection, nil
+}
+
 func createOutDirIfNotExist(path string) (err error) {
 	if _, err := os.Stat(path); os.IsNotExist(err) {
 		return os.Mkdir(path, os.ModeDir|0755)
@@ -391,48 +871,141 @@ func createOutDirIfNotExist(path string) (err error) {
 	return nil
 }
 
-func initDetailFile(outDir string, infilepath string) (err error) {
-	infile := filepath.Base(infilepath)
-
-	// clear and init details file
-	detailsDir := filepath.Join(outDir, "details")
-	err = createOutDirIfNotExist(detailsDir)
+// reportFilePath returns the report file path of an input within dir, creating
+// the directories that mirror the report key
+func reportFilePath(dir string, reportKey string) (path string, err error) {
+	path = fmt.Sprintf("%v.json", filepath.Join(dir, filepath.FromSlash(reportKey)))
+	err = os.MkdirAll(filepath.Dir(path), os.ModeDir|0755)
 	if err != nil {
-		return err
+		return "", err
 	}
-	detailsFile := fmt.Sprintf("%v.json", filepath.Join(detailsDir, infile))
-	err = ioutil.WriteFile(detailsFile, []byte("["), 0644)
-	if err != nil {
-		return err
+	return path, nil
+}
+
+// reportKeys maps each file to its path relative to the input it was listed
+// from, so the key of a file doesn't change with the other files of the run
+// and same-named files in different directories don't overwrite each other
+// reports. The files of a directory are keyed by their path within it, below
+// the name of the directory when there are several inputs, and archive
+// members below the name of their archive. Inputs with the same name are
+// told apart by their parent directories. Compressed files are keyed by
+// their logical name.
+func reportKeys(inputs []string, files []string) (keys map[string]string) {
+	keys = make(map[string]string, len(files))
+
+	// stdin doesn't take part in the names of the inputs
+	var absInputs []string
+	for _, in := range inputs {
+		if in != StdinInput {
+			absInputs = append(absInputs, absPath(in))
+		}
+	}
+	names := inputNames(absInputs)
+
+	relKeys := make([]string, len(files))
+	logicalCounts := make(map[string]int, len(files))
+	for i, f := range files {
+		absFile := absPath(f)
+		key := filepath.ToSlash(filepath.Base(absFile))
+		for _, in := range absInputs {
+			if absFile == in {
+				key = names[in]
+				break
+			}
+			if !isParentDir(in, absFile) {
+				continue
+			}
+			rel, err := filepath.Rel(in, absFile)
+			if err != nil {
+				continue
+			}
+			key = filepath.ToSlash(rel)
+			if len(names) > 1 || !isDir(in) {
+				key = path.Join(names[in], key)
+			}
+			break
+		}
+		relKeys[i] = key
+		logicalCounts[logicalName(key)]++
 	}
 
-	return nil
+	// products.json and products.json.gz side by side keep their full names
+	for i, f := range files {
+		key := logicalName(relKeys[i])
+		if logicalCounts[key] > 1 {
+			key = relKeys[i]
+		}
+		keys[f] = key
+	}
+	return keys
 }
 
-func closeDetailFile(outDir string, infilepath string) (err error) {
-	infile := filepath.Base(infilepath)
+// inputNames names each input by its base name, extended with its parent
+// directories when inputs share the same name
+func inputNames(absInputs []string) (names map[string]string) {
+	names = make(map[string]string, len(absInputs))
+	depths := make(map[string]int, len(absInputs))
+	for _, in := range absInputs {
+		depths[in] = 1
+	}
 
-	// close details file
-	detailsDir := filepath.Join(outDir, "details")
-	err = createOutDirIfNotExist(detailsDir)
-	if err != nil {
-		return err
+	for {
+		byName := map[string][]string{}
+		for in, depth := range depths {
+			names[in] = pathSuffix(in, depth)
+			byName[names[in]] = append(byName[names[in]], in)
+		}
+
+		extended := false
+		for _, same := range byName {
+			if len(same) < 2 {
+				continue
+			}
+			for _, in := range same {
+				if pathSuffix(in, depths[in]+1) != pathSuffix(in, depths[in]) {
+					depths[in]++
+					extended = true
+				}
+			}
+		}
+		if !extended {
+			return names
+		}
 	}
-	detailsFile := fmt.Sprintf("%v.json", filepath.Join(detailsDir, infile))
-	f, err := os.OpenFile(detailsFile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
-	if err != nil {
-		return err
+}
+
+// pathSuffix returns the last depth elements of the absolute path, slash
+// separated and without the volume name
+func pathSuffix(abs string, depth int) string {
+	elems := strings.Split(filepath.ToSlash(strings.TrimPrefix(abs, filepath.VolumeName(abs))), "/")
+	var suffix []string
+	for i := len(elems) - 1; i >= 0 && len(suffix) < depth; i-- {
+		if elems[i] != "" {
+			suffix = append([]string{elems[i]}, suffix...)
+		}
 	}
-	defer f.Close()
-	_, err = f.WriteString("\n]")
+	return strings.Join(suffix, "/")
+}
+
+// absPath returns the absolute path of the file, or its cleaned path
+func absPath(f string) string {
+	abs, err := filepath.Abs(f)
 	if err != nil {
-		return err
+		return filepath.Clean(f)
 	}
+	return abs
+}
 
-	return nil
+// isParentDir tells whether parent is dir or one of its ancestors
+func isParentDir(parent string, dir string) bool {
+	rel, err := filepath.Rel(parent, dir)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
 }
 
-func writeOverallSummaryFile(outDir string, summaryFile string, summaryErrStats map[string]customtypes.ErrorStats) (err error) {
+func writeOverallSummaryFile(outDir string, summaryFile string, summaryErrStats interface{}) (err error) {
 	if err := createOutDirIfNotExist(outDir); err != nil {
 		return err
 	}
@@ -448,107 +1021,90 @@ func writeOverallSummaryFile(outDir string, summaryFile string, summaryErrStats
 	return nil
 }
 
-func writeValidationOutputs(outDir string, infilepath string, recwes []records.RecordGetSetterWithError, errStats map[string]*customtypes.ErrorStat, isFirst bool, includeCollection bool, totalRecWithErrors *int, maxRecsWithErrors int) (err error) {
-	if err := createOutDirIfNotExist(outDir); err != nil {
-		return err
-	}
-
-	infile := filepath.Base(infilepath)
-	detailsDir := filepath.Join(outDir, "details")
-	err = createOutDirIfNotExist(detailsDir)
-	if err != nil {
-		return err
-	}
-
-	recWs := []RecordWrapper{}
-
+func writeValidationOutputs(dw *detailWriter, recwes []records.RecordGetSetterWithError, errStats map[string]*customtypes.ErrorStat, includeCollection bool, maxRecsWithErrors int) (err error) {
 	for _, rec := range recwes {
-		o := records.TransformToRecordJSONB(rec)
-
-		// delete any unused field from datahen's output records
-		if !includeCollection {
-			delete(o, "_collection")
-		}
-
 		errs := rec.GetErrors()
 		if errs == nil {
 			continue
 		}
 
 		// Increment the overal total errors
-		*totalRecWithErrors++
+		dw.recsWithErrors++
 
 		for _, e := range errs {
-			errKey := fmt.Sprintf("%v.%v", e.Field, e.ErrorType)
-
-			// if it doesn't exist then set a new record
-			if errStats[errKey] == nil {
-				es := customtypes.ErrorStat{
-					Field:            e.Field,
-					ErrorType:        e.ErrorType,
-					ErrorDescription: e.Description,
-					ErrorCount:       1,
-					RecordCount:      0,
-				}
-				es.CalculatePercentage()
-				errStats[errKey] = &es
-				continue
-			}
-
-			errStats[errKey].IncErrCount()
+			addErrStat(errStats, e)
 		}
 
 		// if max records with errors is specified, then limit the output
-		if maxRecsWithErrors == -1 || (maxRecsWithErrors > -1 && *totalRecWithErrors <= maxRecsWithErrors) {
-			recWs = append(recWs, RecordWrapper{
-				Errors: errs,
-				Record: o,
-			})
+		if maxRecsWithErrors != NoLimit && dw.recsWithErrors > maxRecsWithErrors {
+			continue
 		}
 
-	}
+		o := records.TransformToRecordJSONB(rec)
 
-	// prepare details data to save and remove array wrappers
-	detailsData, err := json.MarshalIndent(recWs, "", "  ")
-	if err != nil {
-		return err
-	}
-	upperLimit := 1
-	if len(detailsData) > 2 {
-		upperLimit = len(detailsData) - 2
-	}
-	detailsData = detailsData[1:upperLimit]
+		// delete any unused field from datahen's output records
+		if !includeCollection {
+			delete(o, "_collection")
+		}
 
-	// write details data
-	detailsFile := fmt.Sprintf("%v.json", filepath.Join(detailsDir, infile))
-	f, err := os.OpenFile(detailsFile, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	if !isFirst && len(recWs) > 0 {
-		// write a comma to join with previous record when not first record set
-		_, err = f.WriteString(",")
+		err = dw.Write(RecordWrapper{
+			Errors: errs,
+			Record: o,
+		})
 		if err != nil {
 			return err
 		}
 	}
-	_, err = f.Write(detailsData)
-	if err != nil {
-		return err
-	}
 
 	return nil
 }
 
-func writeSummaryOutputs(outDir string, infilepath string, errStats map[string]*customtypes.ErrorStat) (err error) {
+// addErrStat counts the error into the errStats under its field.error_type key
+func addErrStat(errStats map[string]*customtypes.ErrorStat, e records.SchemaError) {
+	errKey := fmt.Sprintf("%v.%v", e.Field, e.ErrorType)
+
+	// if it doesn't exist then set a new record
+	if errStats[errKey] == nil {
+		es := customtypes.ErrorStat{
+			Field:            e.Field,
+			ErrorType:        e.ErrorType,
+			ErrorDescription: e.Description,
+			ErrorCount:       1,
+			RecordCount:      0,
+		}
+		es.CalculatePercentage()
+		errStats[errKey] = &es
+		return
+	}
+
+	errStats[errKey].IncErrCount()
+}
+
+// updateCollectionStats counts the records and errors of each collection,
+// leaving out the records without a collection of their own
+func updateCollectionStats(recwes []records.RecordGetSetterWithError, colErrStats CollectionErrorStats, colRecordCounts map[string]uint64) {
+	for _, rec := range recwes {
+		col := rec.GetCollection()
+		if col == "" || col == defaultCollection {
+			continue
+		}
+		colRecordCounts[col]++
+
+		if colErrStats[col] == nil {
+			colErrStats[col] = customtypes.ErrorStats{}
+		}
+		for _, e := range rec.GetErrors() {
+			addErrStat(colErrStats[col], e)
+		}
+	}
+}
+
+func writeSummaryOutputs(outDir string, reportKey string, errStats interface{}) (err error) {
 	if err := createOutDirIfNotExist(outDir); err != nil {
 		return err
 	}
 
-	infile := filepath.Base(infilepath)
-	summaryDir := filepath.Join(outDir, "summary")
-	err = createOutDirIfNotExist(summaryDir)
+	summaryFile, err := reportFilePath(filepath.Join(outDir, "summary"), reportKey)
 	if err != nil {
 		return err
 	}
@@ -558,7 +1114,6 @@ func writeSummaryOutputs(outDir string, infilepath string, errStats map[string]*
 	if err != nil {
 		return err
 	}
-	summaryFile := fmt.Sprintf("%v.json", filepath.Join(summaryDir, infile))
 	err = ioutil.WriteFile(summaryFile, summaryData, 0644)
 	if err != nil {
 		return err
@@ -567,10 +1122,11 @@ func writeSummaryOutputs(outDir string, infilepath string, errStats map[string]*
 	return nil
 }
 
+// readFile reads the whole file, the callers log the errors through their
+// own logger, if any
 func readFile(filename string) (data []byte, err error) {
 	data, err = ioutil.ReadFile(filename)
 	if err != nil {
-		fmt.Println("gotten error reading ", filename, ":", err.Error())
 		return nil, err
 	}
 
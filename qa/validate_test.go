@@ -0,0 +1,172 @@
+package qa
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/DataHenHQ/datahen/records"
+)
+
+func TestReportKeys(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"exports/a/x.json":              "[]",
+		"exports/b/y.json":              "[]",
+		"exports/b/y.json.gz":           "",
+		"exports/c/z.ndjson.gz":         "",
+		"siteA/data/products.json":      "[]",
+		"siteB/data/products.json":      "[]",
+		"siteA/data/more/products.json": "[]",
+		"archive.zip":                   "",
+	})
+	p := func(name string) string { return filepath.Join(dir, filepath.FromSlash(name)) }
+
+	tests := []struct {
+		name   string
+		inputs []string
+		files  []string
+		want   map[string]string
+	}{
+		{
+			name:   "directory",
+			inputs: []string{p("exports")},
+			files:  []string{p("exports/a/x.json"), p("exports/b/y.json"), p("exports/b/y.json.gz"), p("exports/c/z.ndjson.gz")},
+			want: map[string]string{
+				p("exports/a/x.json"):      "a/x.json",
+				p("exports/b/y.json"):      "b/y.json",
+				p("exports/b/y.json.gz"):   "b/y.json.gz",
+				p("exports/c/z.ndjson.gz"): "c/z.ndjson",
+			},
+		},
+		{
+			name:   "directories with the same name",
+			inputs: []string{p("siteA/data"), p("siteB/data"), p("exports")},
+			files:  []string{p("siteA/data/products.json"), p("siteA/data/more/products.json"), p("siteB/data/products.json"), p("exports/a/x.json")},
+			want: map[string]string{
+				p("siteA/data/products.json"):      "siteA/data/products.json",
+				p("siteA/data/more/products.json"): "siteA/data/more/products.json",
+				p("siteB/data/products.json"):      "siteB/data/products.json",
+				p("exports/a/x.json"):              "exports/a/x.json",
+			},
+		},
+		{
+			name:   "files with the same name",
+			inputs: []string{p("siteA/data/products.json"), p("siteB/data/products.json"), p("exports/a/x.json")},
+			files:  []string{p("siteA/data/products.json"), p("siteB/data/products.json"), p("exports/a/x.json")},
+			want: map[string]string{
+				p("siteA/data/products.json"): "siteA/data/products.json",
+				p("siteB/data/products.json"): "siteB/data/products.json",
+				p("exports/a/x.json"):         "x.json",
+			},
+		},
+		{
+			name:   "archive",
+			inputs: []string{p("archive.zip")},
+			files:  []string{p("archive.zip/inner/x.json")},
+			want:   map[string]string{p("archive.zip/inner/x.json"): "archive.zip/inner/x.json"},
+		},
+		{
+			name:   "stdin",
+			inputs: []string{StdinInput, p("exports/b/y.json.gz")},
+			files:  []string{StdinInput, p("exports/b/y.json.gz")},
+			want:   map[string]string{StdinInput: "-", p("exports/b/y.json.gz"): "y.json"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := reportKeys(tt.inputs, tt.files); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got keys %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReportKeysStable(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"exports/a/x.json": "[]",
+		"exports/b/y.json": "[]",
+		"exports/z.json":   "[]",
+	})
+	inputs := []string{filepath.Join(dir, "exports")}
+	x := filepath.Join(dir, "exports", "a", "x.json")
+
+	runs := [][]string{
+		{x},
+		{x, filepath.Join(dir, "exports", "b", "y.json")},
+		{filepath.Join(dir, "exports", "z.json"), x},
+	}
+	for _, files := range runs {
+		if got := reportKeys(inputs, files)[x]; got != "a/x.json" {
+			t.Errorf("got key %q with files %v, want a/x.json", got, files)
+		}
+	}
+}
+
+// gzipString compresses the string with gzip
+func gzipString(t *testing.T, s string) string {
+	t.Helper()
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestUpdateCollectionStats(t *testing.T) {
+	product := newMapRecord(map[string]interface{}{"_collection": "products"})
+	product.AddErrors(records.SchemaError{Field: "id", ErrorType: "required"})
+	recs := []records.RecordGetSetterWithError{
+		newMapRecord(map[string]interface{}{"id": 1}),
+		product,
+		newMapRecord(map[string]interface{}{"_collection": "products", "id": 2}),
+	}
+	noCollection := newMapRecord(map[string]interface{}{"id": 3})
+	noCollection.SetCollection("")
+	recs = append(recs, noCollection)
+
+	colErrStats := CollectionErrorStats{}
+	colRecordCounts := map[string]uint64{}
+	updateCollectionStats(recs, colErrStats, colRecordCounts)
+
+	if !reflect.DeepEqual(colRecordCounts, map[string]uint64{"products": 2}) {
+		t.Errorf("got collection record counts %v, want products only", colRecordCounts)
+	}
+	if len(colErrStats) != 1 || colErrStats["products"]["id.required"] == nil {
+		t.Errorf("got collection error stats %v, want the products errors only", colErrStats)
+	}
+}
+
+func TestRunCollectionSummaries(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"data/plain.ndjson.gz":       gzipString(t, "{\"id\": 1}\n{\"name\": \"x\"}\n"),
+		"data/collections.ndjson.gz": gzipString(t, "{\"_collection\": \"products\", \"id\": 1}\n{\"id\": 2}\n"),
+	})
+
+	opts := newTestOptions(t, dir, filepath.Join(dir, "data"))
+	result, err := NewValidator(opts).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(opts.OutDir, "summary", "plain.ndjson.collections.json")); !os.IsNotExist(err) {
+		t.Errorf("got a collection summary for records without collection: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(opts.OutDir, "summary", "collections.ndjson.collections.json")); err != nil {
+		t.Errorf("got no collection summary for records with a collection: %v", err)
+	}
+	if fr := result.Files["plain.ndjson"]; fr == nil || len(fr.CollectionErrorStats) > 0 {
+		t.Errorf("got plain.ndjson result %+v, want no collection stats", fr)
+	}
+}
@@ -0,0 +1,370 @@
+package qa
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/DataHenHQ/henqa_shared/customtypes"
+)
+
+// Options configures a Validator.
+type Options struct {
+	// Inputs are the files and directories to validate. StdinInput ("-") reads
+	// the records from Stdin.
+	Inputs []string
+	// Stdin is read for the StdinInput, defaults to os.Stdin.
+	Stdin io.Reader
+	// InputFormat is the format of the records read from Stdin: InputFormatCSV,
+	// InputFormatJSON or InputFormatNDJSON. Defaults to the format of the
+	// StdinName extension, or else NDJSON.
+	InputFormat string
+	// StdinName names the reports of the records read from Stdin, defaults to "stdin".
+	StdinName string
+	// CSVDialect describes how the CSV files are written, defaults to comma delimited
+	// UTF-8 files with a header row. .tsv files are tab delimited unless set.
+	CSVDialect CSVDialect
+	// CSVDialects override CSVDialect for the files whose report path match their pattern.
+	CSVDialects []CSVDialectRule
+	// XLSXSheets are the names or 1-based indexes of the sheets read from Excel files,
+	// all sheets when empty. Each sheet is a collection named after the sheet.
+	XLSXSheets []string
+	// XLSXHeaderRow is the 1-based row of the column names in Excel sheets, the first
+	// non empty row when 0.
+	XLSXHeaderRow int
+	// Coerce converts the CSV values to the types declared by the schema properties before
+	// validation. Values that cannot be converted get a coercion_failed error.
+	Coerce bool
+	// CoerceArraySeparator separates the items of CSV values coerced to arrays, defaults to ",".
+	CoerceArraySeparator string
+	// Include are the doublestar glob patterns, such as "**/*.json", of the files to
+	// validate inside the input directories and archives. Empty means all files.
+	Include []string
+	// Exclude are the doublestar glob patterns of the files and directories to leave out.
+	Exclude []string
+	// NoRecursive only validates the files directly inside the input directories.
+	NoRecursive bool
+	// Hidden validates dotfiles and the files of dot directories, which are skipped by default.
+	Hidden bool
+	// Schemas are the JSON schema files, the latter will merge with the former. Schemas
+	// can also be http(s) URLs, pinned to their SHA-256 checksum with a #sha256=<hex> fragment.
+	Schemas []string
+	// SchemaCacheDir keeps the remote schemas, revalidated with their ETag on each run.
+	// Defaults to henqa/schemas in the user cache directory.
+	SchemaCacheDir string
+	// Offline only uses the cached copies of the remote schemas.
+	Offline bool
+	// HTTPClient fetches the remote schemas, defaults to a client timing out after
+	// DefaultSchemaFetchTimeout.
+	HTTPClient *http.Client
+	// MergeStrategy combines the schema files: MergeStrategyMergePatch (default),
+	// MergeStrategyDeep, MergeStrategyJSONPatch or MergeStrategyAllOf.
+	MergeStrategy string
+	// SchemaDirs are the directories of the schemas that $ref can point to, by their $id
+	// or their path relative to the directory. The schema files are always registered.
+	SchemaDirs []string
+	// CollectionSchemas maps collection names to their own JSON schema files.
+	CollectionSchemas map[string][]string
+	// UniqueKey are the fields whose values together must be unique across the records of
+	// each file. Duplicates get a duplicate_key error with the index of the earlier record.
+	UniqueKey []string
+	// UniqueKeyAcrossFiles checks the UniqueKey across all the files of the run. With
+	// Parallel, which of two records of different files is reported as the duplicate
+	// depends on the order their files are validated in, so it can change between runs.
+	UniqueKeyAcrossFiles bool
+	// UniqueKeyMemoryLimit is the number of unique keys held in memory before they spill
+	// to disk, defaults to DefaultUniqueKeyMemoryLimit.
+	UniqueKeyMemoryLimit int
+	// Profile computes the statistics of the fields of each file while validating it, saved
+	// as profile/<file>.json next to the summary and details.
+	Profile bool
+	// ProfileTopN is the number of most frequent values profiled per field, defaults to
+	// DefaultProfileTopN.
+	ProfileTopN int
+	// Workflow is the name of the workflow that will be executed.
+	Workflow string
+	// Vars seeds the workflow variables of each run. Workflows that keep gvars
+	// share them across the files of a run, otherwise each file gets a deep copy.
+	Vars map[string]interface{}
+	// OutDir is the reports output directory, defaults to "reports".
+	OutDir string
+	// SummaryFile is the name of the overall summary file, defaults to "summary".
+	SummaryFile string
+	// Baseline is the reports folder of a previous validation to compare the summary
+	// against. The comparison is saved as <SummaryFile>.diff.json and kept in the result.
+	Baseline string
+	// Formats are the report formats written in addition to the JSON reports, such as "html" or "junit".
+	Formats []string
+	// DetailsFormat is the details file format, DetailsFormatJSON (default) or DetailsFormatNDJSON.
+	DetailsFormat string
+	// BatchSize is the number of records processed at a time, defaults to 10000.
+	BatchSize int
+	// Parallel is the number of files validated at the same time, defaults to 1.
+	// Workflows that keep gvars across files are always validated sequentially.
+	Parallel int
+	// MaxRecsWithErrors limits the records with errors saved into each details file, 0 saves
+	// none. Nil or NoLimit means no limit.
+	MaxRecsWithErrors *int
+	// Logger receives the progress messages, defaults to os.Stdout. Use ioutil.Discard to silence it.
+	Logger io.Writer
+}
+
+// NoLimit is the MaxRecsWithErrors saving every record with errors.
+const NoLimit = -1
+
+// Validator validates input files using JSON schemas and workflows and writes reports.
+type Validator struct {
+	opts  Options
+	logMu sync.Mutex
+	tars  tarCursors
+	// maxRecsWithErrors is the MaxRecsWithErrors option, NoLimit when nil
+	maxRecsWithErrors int
+}
+
+// NewValidator returns a Validator for the options, filling in the defaults.
+func NewValidator(opts Options) *Validator {
+	if opts.OutDir == "" {
+		opts.OutDir = "reports"
+	}
+	if opts.SummaryFile == "" {
+		opts.SummaryFile = "summary"
+	}
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 10000
+	}
+	if opts.DetailsFormat == "" {
+		opts.DetailsFormat = DetailsFormatJSON
+	}
+	if opts.Parallel < 1 {
+		opts.Parallel = 1
+	}
+	if opts.MergeStrategy == "" {
+		opts.MergeStrategy = MergeStrategyMergePatch
+	}
+	if opts.SchemaCacheDir == "" {
+		opts.SchemaCacheDir = defaultSchemaCacheDir()
+	}
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = &http.Client{Timeout: DefaultSchemaFetchTimeout}
+	}
+	if opts.ProfileTopN < 1 {
+		opts.ProfileTopN = DefaultProfileTopN
+	}
+	if opts.UniqueKeyMemoryLimit < 1 {
+		opts.UniqueKeyMemoryLimit = DefaultUniqueKeyMemoryLimit
+	}
+	if opts.CoerceArraySeparator == "" {
+		opts.CoerceArraySeparator = DefaultCoerceArraySeparator
+	}
+	if opts.Stdin == nil {
+		opts.Stdin = os.Stdin
+	}
+	if opts.StdinName == "" {
+		opts.StdinName = "stdin"
+	}
+	if opts.Logger == nil {
+		opts.Logger = os.Stdout
+	}
+	maxRecsWithErrors := NoLimit
+	if opts.MaxRecsWithErrors != nil && *opts.MaxRecsWithErrors >= 0 {
+		maxRecsWithErrors = *opts.MaxRecsWithErrors
+	}
+	return &Validator{opts: opts, maxRecsWithErrors: maxRecsWithErrors}
+}
+
+// Run validates the inputs, writes the reports and returns the validation result.
+// When ctx is done the run stops after closing the reports of the current file,
+// and the partial result is returned along with the context error. The result
+// is also returned along with an *InputError when some inputs are missing or
+// were skipped, and ErrNoInputFiles is returned when there is no file to validate.
+func (v *Validator) Run(ctx context.Context) (result *ValidationResult, err error) {
+	opts := v.opts
+
+	if len(opts.Schemas) == 0 && len(opts.CollectionSchemas) == 0 && opts.Workflow == "" && len(opts.UniqueKey) == 0 {
+		v.logln(ErrNoSchemaOrWorkflow.Error())
+		v.logln("aborting validation.")
+		return nil, ErrNoSchemaOrWorkflow
+	}
+
+	// read the baseline first to fail before validating
+	var baselineStats map[string]customtypes.ErrorStats
+	if opts.Baseline != "" {
+		baselineStats, err = readOverallSummaryFile(opts.Baseline, opts.SummaryFile)
+		if err != nil {
+			v.logln("gotten error reading the baseline:", err.Error())
+			v.logln("aborting validation.")
+			return nil, err
+		}
+	}
+
+	// fail before validating when there is nothing to validate
+	files, missing := v.getListOfFiles(opts.Inputs)
+	if len(files) == 0 {
+		err = ErrNoInputFiles
+		if len(missing) > 0 {
+			err = &InputError{Missing: missing}
+		}
+		v.logln(err.Error())
+		v.logln("aborting validation.")
+		return nil, err
+	}
+
+	// replace the remote schemas by their cached copies
+	schemas, remoteURLs, err := v.fetchRemoteSchemas(ctx, opts.Schemas)
+	if err != nil {
+		v.logln("gotten error with fetching schemas:", err.Error())
+		v.logln("aborting validation.")
+		return nil, err
+	}
+	colSchemaFiles := map[string][]string{}
+	for col, colFiles := range opts.CollectionSchemas {
+		colFiles, colURLs, err := v.fetchRemoteSchemas(ctx, colFiles)
+		if err != nil {
+			v.logf("gotten error with fetching schemas of collection %v: %v\n", col, err.Error())
+			v.logln("aborting validation.")
+			return nil, err
+		}
+		for f, u := range colURLs {
+			remoteURLs[f] = u
+		}
+		colSchemaFiles[col] = colFiles
+	}
+
+	// register the local and cached remote schemas that $ref can point to
+	schemaFiles := append([]string{}, schemas...)
+	for _, colFiles := range colSchemaFiles {
+		schemaFiles = append(schemaFiles, colFiles...)
+	}
+	reg, err := v.newSchemaRegistry(ctx, schemaFiles, remoteURLs)
+	if err != nil {
+		v.logln("gotten error with registering schemas:", err.Error())
+		v.logln("aborting validation.")
+		return nil, err
+	}
+
+	mergedSchema, err := v.getAndMergeSchemaFiles(schemas, reg)
+	if err != nil {
+		v.logln("gotten error with merging schemas:", err.Error())
+		v.logln("aborting validation.")
+		return nil, err
+	}
+
+	// ensure output dir exists
+	err = createOutDirIfNotExist(opts.OutDir)
+	if err != nil {
+		v.logln("gotten error creating output directory:", err.Error())
+		v.logln("aborting validation.")
+		return nil, err
+	}
+
+	// if schema is empty, pass validation of everything by using {}
+	if len(mergedSchema) == 0 {
+		mergedSchema = []byte("{}")
+	}
+
+	// merge the schemas of each collection
+	mergedColSchemas := map[string][]byte{}
+	for col, colFiles := range colSchemaFiles {
+		colSchema, err := v.getAndMergeSchemaFiles(colFiles, reg)
+		if err != nil {
+			v.logf("gotten error with merging schemas of collection %v: %v\n", col, err.Error())
+			v.logln("aborting validation.")
+			return nil, err
+		}
+		if len(colSchema) == 0 {
+			colSchema = []byte("{}")
+		}
+		mergedColSchemas[col] = colSchema
+	}
+
+	result, err = v.validateWithSchema(ctx, files, mergedSchema, mergedColSchemas)
+	if result != nil && baselineStats != nil {
+		if ferr := v.compareBaseline(result, baselineStats); ferr != nil {
+			v.logln("gotten error comparing the baseline:", ferr.Error())
+			v.logln("aborting validation.")
+			return nil, ferr
+		}
+	}
+	if result != nil {
+		if ferr := v.writeReportFormats(); ferr != nil {
+			v.logln("gotten error writing the reports:", ferr.Error())
+			v.logln("aborting validation.")
+			return nil, ferr
+		}
+	}
+	if err != nil && result != nil {
+		v.logln("validation stopped:", err.Error())
+		v.logln("The partial report folder would be located at", opts.OutDir)
+		return result, err
+	}
+	if err != nil {
+		v.logln("gotten error running the validation:", err.Error())
+		v.logln("aborting validation.")
+		return nil, err
+	}
+
+	// missing and skipped inputs fail the run, with the reports of the other files
+	result.Missing = missing
+	if len(result.Missing) > 0 || len(result.Skipped) > 0 {
+		err = &InputError{Missing: result.Missing, Skipped: result.Skipped}
+		v.logln("validation incomplete:", err.Error())
+		v.logln("The report folder would be located at", opts.OutDir)
+		return result, err
+	}
+
+	v.logln("Done validating record. The report folder would be located at", opts.OutDir)
+	return result, nil
+}
+
+// compareBaseline diffs the summary of the result against the baseline,
+// logging and saving the comparison
+func (v *Validator) compareBaseline(result *ValidationResult, baselineStats map[string]customtypes.ErrorStats) (err error) {
+	result.Baseline = DiffSummaries(baselineStats, result.ErrorStats())
+
+	var b strings.Builder
+	if err := WriteSummaryDiff(&b, result.Baseline); err != nil {
+		return err
+	}
+	v.logf("compared to baseline %v:\n%v", v.opts.Baseline, b.String())
+	return writeOverallSummaryFile(v.opts.OutDir, fmt.Sprintf("%v.diff", v.opts.SummaryFile), result.Baseline)
+}
+
+// writeReportFormats writes the additional report formats from the JSON reports
+func (v *Validator) writeReportFormats() (err error) {
+	for _, format := range v.opts.Formats {
+		switch format {
+		case "json":
+			// always written
+		case "html":
+			htmlFile := filepath.Join(v.opts.OutDir, fmt.Sprintf("%v.html", v.opts.SummaryFile))
+			if err := WriteHTMLReport(v.opts.OutDir, v.opts.SummaryFile, htmlFile, DefaultHTMLSamples); err != nil {
+				return err
+			}
+		case "junit":
+			xmlFile := filepath.Join(v.opts.OutDir, fmt.Sprintf("%v.junit.xml", v.opts.SummaryFile))
+			if err := WriteJUnitReport(v.opts.OutDir, v.opts.SummaryFile, xmlFile, DefaultJUnitValues); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unknown report format %q", format)
+		}
+	}
+	return nil
+}
+
+func (v *Validator) logf(format string, a ...interface{}) {
+	v.logMu.Lock()
+	defer v.logMu.Unlock()
+	fmt.Fprintf(v.opts.Logger, format, a...)
+}
+
+func (v *Validator) logln(a ...interface{}) {
+	v.logMu.Lock()
+	defer v.logMu.Unlock()
+	fmt.Fprintln(v.opts.Logger, a...)
+}
@@ -0,0 +1,347 @@
+package qa
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// testSchema requires an integer id
+const testSchema = `{"type": "object", "required": ["id"], "properties": {"id": {"type": "integer"}}}`
+
+// newTestOptions validates the inputs against testSchema, written under dir,
+// and writes the reports into a temp dir
+func newTestOptions(t *testing.T, dir string, inputs ...string) Options {
+	t.Helper()
+	writeTestFiles(t, dir, map[string]string{"schema.json": testSchema})
+	return Options{
+		Inputs:  inputs,
+		Schemas: []string{filepath.Join(dir, "schema.json")},
+		OutDir:  filepath.Join(t.TempDir(), "reports"),
+		Logger:  ioutil.Discard,
+	}
+}
+
+// testSchemaLoaders loads the schema as the schema of the default collection
+func testSchemaLoaders(t *testing.T, schema string) map[string]*gojsonschema.JSONLoader {
+	t.Helper()
+	loader, err := loadSchema([]byte(schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]*gojsonschema.JSONLoader{defaultCollection: loader}
+}
+
+func TestRunIgnoresUnsupportedFilesOfDirectories(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"data/products.ndjson":      "{\"id\": 1}\n{\"id\": 2}\n",
+		"data/README":               "Daily exports",
+		"data/export.log":           "done",
+		"data/notes/summary.txt":    "nothing to see",
+		"data/archive/old.json.bak": "[]",
+	})
+
+	result, err := NewValidator(newTestOptions(t, dir, filepath.Join(dir, "data"))).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Skipped) > 0 || len(result.Missing) > 0 {
+		t.Errorf("got skipped %v and missing %v", result.Skipped, result.Missing)
+	}
+	if len(result.Files) != 1 || result.Files["products.ndjson"] == nil {
+		t.Errorf("got files %v, want products.ndjson only", result.Files)
+	}
+	if err := CheckThresholds(result, Thresholds{FailOnErrors: true, MaxErrorPercent: -1, MaxErrorIncrease: -1}); err != nil {
+		t.Errorf("got thresholds error %v", err)
+	}
+}
+
+func TestRunFailsOnUnsupportedInputs(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"products.ndjson": "{\"id\": 1}\n",
+		"README":          "Daily exports",
+	})
+	readme := filepath.Join(dir, "README")
+
+	result, err := NewValidator(newTestOptions(t, dir, filepath.Join(dir, "products.ndjson"), readme)).Run(context.Background())
+	var inputErr *InputError
+	if !errors.As(err, &inputErr) {
+		t.Fatalf("got error %v, want an input error", err)
+	}
+	if len(inputErr.Skipped) != 1 || inputErr.Skipped[0] != readme {
+		t.Errorf("got skipped inputs %v, want %v", inputErr.Skipped, readme)
+	}
+	if result == nil || len(result.Files) != 1 {
+		t.Fatalf("got result %v, want the reports of the other input", result)
+	}
+	if err := CheckThresholds(result, Thresholds{MaxErrorPercent: -1, MaxErrorIncrease: -1}); err == nil {
+		t.Error("got thresholds passing with a skipped input")
+	}
+
+	// only unsupported files in a directory leave nothing to validate
+	writeTestFiles(t, dir, map[string]string{"docs/README": "Daily exports"})
+	_, err = NewValidator(newTestOptions(t, dir, filepath.Join(dir, "docs"))).Run(context.Background())
+	if err != ErrNoInputFiles {
+		t.Errorf("got error %v, want no input files", err)
+	}
+}
+
+// readTestDetails reads the details array of the report key
+func readTestDetails(t *testing.T, outDir string, reportKey string) (details []RecordWrapper) {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join(outDir, "details", reportKey+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, &details); err != nil {
+		t.Fatalf("got invalid details %s: %v", data, err)
+	}
+	return details
+}
+
+func TestRunMaxRecsWithErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"products.ndjson": "{\"id\": \"a\"}\n{\"id\": 2}\n{\"name\": \"c\"}\n{\"id\": \"d\"}\n",
+	})
+
+	limit := func(n int) *int { return &n }
+	tests := []struct {
+		max  *int
+		want int
+	}{
+		{nil, 3},
+		{limit(NoLimit), 3},
+		{limit(0), 0},
+		{limit(2), 2},
+		{limit(5), 3},
+	}
+
+	for _, tt := range tests {
+		name := "nil"
+		if tt.max != nil {
+			name = fmt.Sprint(*tt.max)
+		}
+		t.Run(name, func(t *testing.T) {
+			opts := newTestOptions(t, dir, filepath.Join(dir, "products.ndjson"))
+			opts.MaxRecsWithErrors = tt.max
+			result, err := NewValidator(opts).Run(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(readTestDetails(t, opts.OutDir, "products.ndjson")); got != tt.want {
+				t.Errorf("got %v records with errors saved, want %v", got, tt.want)
+			}
+
+			// the limit only applies to the details, not to the stats
+			var errCount uint64
+			for _, es := range result.Files["products.ndjson"].ErrorStats {
+				errCount += es.ErrorCount
+			}
+			if errCount != 3 {
+				t.Errorf("got %v errors counted, want 3", errCount)
+			}
+		})
+	}
+}
+
+// cancelWriter cancels the run once the first batch is validated, logged as a dot
+type cancelWriter struct {
+	cancel context.CancelFunc
+}
+
+func (w *cancelWriter) Write(p []byte) (int, error) {
+	if string(p) == "." {
+		w.cancel()
+	}
+	return len(p), nil
+}
+
+// readTestStatus reads the status of the overall summary
+func readTestStatus(t *testing.T, outDir string) (status validationStatus) {
+	t.Helper()
+	status, err := readStatusFile(outDir, "summary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+// ndjsonRecords builds n records, every other one without an integer id
+func ndjsonRecords(n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		if i%2 == 0 {
+			fmt.Fprintf(&b, "{\"id\": %d}\n", i)
+		} else {
+			fmt.Fprintf(&b, "{\"id\": \"%d\"}\n", i)
+		}
+	}
+	return b.String()
+}
+
+func TestRunCancelled(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{
+		"data/a.ndjson": ndjsonRecords(100),
+		"data/b.ndjson": ndjsonRecords(100),
+	})
+
+	for _, parallel := range []int{1, 2} {
+		t.Run(fmt.Sprint(parallel), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			opts := newTestOptions(t, dir, filepath.Join(dir, "data"))
+			opts.BatchSize = 10
+			opts.Parallel = parallel
+			opts.Logger = &cancelWriter{cancel: cancel}
+
+			result, err := NewValidator(opts).Run(ctx)
+			if err != context.Canceled {
+				t.Fatalf("got error %v, want context canceled", err)
+			}
+			if result == nil || result.Complete {
+				t.Fatalf("got result %+v, want a partial result", result)
+			}
+			if len(result.Files) == 0 || len(result.Files) > parallel {
+				t.Fatalf("got %v files validated, want the files under way only", len(result.Files))
+			}
+			if result.RecordCount() == 0 {
+				t.Error("got no records validated before the cancellation")
+			}
+			for key, fr := range result.Files {
+				if !fr.Incomplete || fr.RecordCount >= 100 {
+					t.Errorf("got %v incomplete %v with %v records, want it stopped early", key, fr.Incomplete, fr.RecordCount)
+				}
+				// the partial details are closed
+				if details := readTestDetails(t, opts.OutDir, key); uint64(len(details)) > fr.RecordCount {
+					t.Errorf("got %v details of %v records for %v", len(details), fr.RecordCount, key)
+				}
+			}
+
+			status := readTestStatus(t, opts.OutDir)
+			want := validationStatus{Complete: false, Error: context.Canceled.Error(), FileCount: len(result.Files), RecordCount: result.RecordCount(), FileRecordCounts: map[string]uint64{}}
+			for key, fr := range result.Files {
+				want.FileRecordCounts[key] = fr.RecordCount
+			}
+			if !reflect.DeepEqual(status, want) {
+				t.Errorf("got status %+v, want %+v", status, want)
+			}
+		})
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, map[string]string{"data/a.ndjson": ndjsonRecords(10)})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	opts := newTestOptions(t, dir, filepath.Join(dir, "data"))
+	result, err := NewValidator(opts).Run(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("got error %v, want deadline exceeded", err)
+	}
+	if result == nil || result.Complete || result.RecordCount() != 0 {
+		t.Fatalf("got result %+v, want nothing validated", result)
+	}
+	if status := readTestStatus(t, opts.OutDir); status.Complete || status.Error != context.DeadlineExceeded.Error() {
+		t.Errorf("got status %+v, want incomplete after the deadline", status)
+	}
+
+	// a complete run
+	result, err = NewValidator(opts).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := readTestStatus(t, opts.OutDir); !status.Complete || status.Error != "" || status.FileCount != 1 || status.RecordCount != 10 {
+		t.Errorf("got status %+v, want complete", status)
+	}
+	if fr := result.Files["a.ndjson"]; fr == nil || fr.Incomplete {
+		t.Errorf("got file result %+v, want complete", fr)
+	}
+}
+
+// readTestReports reads all the report files of the run by their path
+func readTestReports(t *testing.T, outDir string) (reports map[string]string) {
+	t.Helper()
+	reports = map[string]string{}
+	err := filepath.Walk(outDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(outDir, path)
+		reports[filepath.ToSlash(rel)] = string(data)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reports
+}
+
+func TestRunParallelSameReports(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"data/products.csv":          "id,name\n1,a\nx,b\n3,c\n",
+		"data/more/items.json":       `[{"id": 1}, {"name": "b"}, {"id": 3.5}]`,
+		"data/collections.ndjson.gz": gzipString(t, "{\"_collection\": \"products\", \"id\": \"a\"}\n{\"_collection\": \"reviews\", \"id\": 2}\n"),
+	}
+	for i := 0; i < 12; i++ {
+		files[fmt.Sprintf("data/batch/%02d.ndjson", i)] = ndjsonRecords(20 + i)
+	}
+	writeTestFiles(t, dir, files)
+
+	run := func(parallel int) (*ValidationResult, map[string]string) {
+		t.Helper()
+		opts := newTestOptions(t, dir, filepath.Join(dir, "data"))
+		opts.Parallel = parallel
+		opts.BatchSize = 7
+		opts.UniqueKey = []string{"id"}
+		opts.Profile = true
+		result, err := NewValidator(opts).Run(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result, readTestReports(t, opts.OutDir)
+	}
+
+	sequential, seqReports := run(1)
+	if len(sequential.Files) != 15 {
+		t.Fatalf("got %v files validated, want 15", len(sequential.Files))
+	}
+	for _, parallel := range []int{2, 4, 16} {
+		t.Run(fmt.Sprint(parallel), func(t *testing.T) {
+			result, reports := run(parallel)
+			if !reflect.DeepEqual(result.ErrorStats(), sequential.ErrorStats()) {
+				t.Errorf("got summary %v, want %v", result.ErrorStats(), sequential.ErrorStats())
+			}
+			if result.RecordCount() != sequential.RecordCount() {
+				t.Errorf("got %v records, want %v", result.RecordCount(), sequential.RecordCount())
+			}
+			if len(reports) != len(seqReports) {
+				t.Errorf("got %v reports, want %v", len(reports), len(seqReports))
+			}
+			for name, want := range seqReports {
+				if got, ok := reports[name]; !ok || got != want {
+					t.Errorf("got %v:\n%v\nwant:\n%v", name, got, want)
+				}
+			}
+		})
+	}
+}
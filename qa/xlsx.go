@@ -0,0 +1,272 @@
+package qa
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DataHenHQ/datahen/records"
+	"github.com/xuri/excelize/v2"
+)
+
+// xlsxOptions selects the sheets and the header row of Excel files
+type xlsxOptions struct {
+	// sheets are the names or 1-based indexes of the sheets to read, all when empty
+	sheets []string
+	// headerRow is the 1-based row holding the column names, the first non
+	// empty row when 0
+	headerRow int
+}
+
+// xlsxFileProcessor reads the records of the selected sheets of Excel files,
+// each sheet as a collection named after the sheet
+func xlsxFileProcessor(xo xlsxOptions) processFileStreamFn {
+	return func(filename string, batchSize int, configReaderFn records.ConfigReaderFn, validateFn records.ValidateFn, validatePreRecords records.ValidateHeadersFn) (err error) {
+		f, err := excelize.OpenFile(filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		sheets, err := selectSheets(f.GetSheetList(), xo.sheets)
+		if err != nil {
+			return err
+		}
+
+		xr := &xlsxReader{f: f, dateFormats: xlsxDateFormats(f)}
+		if f.WorkBook != nil && f.WorkBook.WorkbookPr != nil {
+			xr.date1904 = f.WorkBook.WorkbookPr.Date1904
+		}
+
+		batcher := newRecordBatcher(batchSize, validateFn)
+		for _, sheet := range sheets {
+			err = xr.readSheet(sheet, xo.headerRow, batcher, validatePreRecords)
+			if err != nil {
+				return fmt.Errorf("sheet %v: %v", sheet, err)
+			}
+		}
+		return batcher.Flush()
+	}
+}
+
+// selectSheets returns the sheets matching the names or 1-based indexes,
+// names taking precedence
+func selectSheets(list []string, selected []string) (sheets []string, err error) {
+	if len(selected) == 0 {
+		return list, nil
+	}
+
+	for _, s := range selected {
+		found := false
+		for _, name := range list {
+			if name == s {
+				sheets = append(sheets, name)
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+
+		i, err := strconv.Atoi(s)
+		if err != nil || i < 1 || i > len(list) {
+			return nil, fmt.Errorf("sheet %q not found", s)
+		}
+		sheets = append(sheets, list[i-1])
+	}
+	return uniqueStringSlice(sheets), nil
+}
+
+type xlsxReader struct {
+	f           *excelize.File
+	dateFormats map[int]string
+	date1904    bool
+	// colLayouts caches the date layout of the columns of the sheet being
+	// read, "" when not a date, looked up from the style of their first
+	// number since exports format whole columns alike
+	colLayouts map[int]string
+}
+
+func (xr *xlsxReader) readSheet(sheet string, headerRow int, batcher *recordBatcher, validatePreRecords records.ValidateHeadersFn) (err error) {
+	rows, err := xr.f.Rows(sheet)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	xr.colLayouts = map[int]string{}
+
+	var headers []string
+	for r := 1; rows.Next(); r++ {
+		cols, err := rows.Columns(excelize.Options{RawCellValue: true})
+		if err != nil {
+			return err
+		}
+
+		// the header row is the first non empty one unless set
+		if headers == nil {
+			if (headerRow == 0 && isEmptyRow(cols)) || (headerRow > 0 && r < headerRow) {
+				continue
+			}
+			headers = make([]string, len(cols))
+			for i, c := range cols {
+				headers[i] = strings.TrimSpace(c)
+			}
+			if validatePreRecords != nil {
+				stop, err := validatePreRecords(headers)
+				if err != nil || stop {
+					return err
+				}
+			}
+			continue
+		}
+
+		if isEmptyRow(cols) {
+			continue
+		}
+
+		data := map[string]interface{}{"_collection": sheet}
+		for i, raw := range cols {
+			if i >= len(headers) || headers[i] == "" || raw == "" {
+				continue
+			}
+			data[headers[i]], err = xr.cellValue(sheet, i+1, r, raw)
+			if err != nil {
+				return err
+			}
+		}
+		if err := batcher.Add(data); err != nil {
+			return err
+		}
+	}
+	return rows.Error()
+}
+
+// cellValue converts the raw value of the cell by its type: booleans,
+// numbers as integers when whole, dates as "2006-01-02" or RFC 3339 strings
+// and times as "15:04:05"
+func (xr *xlsxReader) cellValue(sheet string, col int, row int, raw string) (value interface{}, err error) {
+	// text that is neither a number nor a boolean needs no lookup of its type
+	n, numErr := strconv.ParseFloat(raw, 64)
+	isBool := strings.EqualFold(raw, "true") || strings.EqualFold(raw, "false")
+	if numErr != nil && !isBool {
+		return raw, nil
+	}
+
+	axis, err := excelize.CoordinatesToCellName(col, row)
+	if err != nil {
+		return nil, err
+	}
+	cellType, err := xr.f.GetCellType(sheet, axis)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cellType {
+	case excelize.CellTypeBool:
+		return raw == "1" || strings.EqualFold(raw, "true"), nil
+	case excelize.CellTypeNumber, excelize.CellTypeUnset:
+		if numErr != nil {
+			return raw, nil
+		}
+
+		layout, ok := xr.colLayouts[col]
+		if !ok {
+			style, err := xr.f.GetCellStyle(sheet, axis)
+			if err != nil {
+				return nil, err
+			}
+			layout = xr.dateFormats[style]
+			xr.colLayouts[col] = layout
+		}
+		if layout != "" {
+			t, err := excelize.ExcelDateToTime(n, xr.date1904)
+			if err != nil {
+				return raw, nil
+			}
+			if n < 1 && layout != "2006-01-02" {
+				return t.Format("15:04:05"), nil
+			}
+			return t.Format(layout), nil
+		}
+
+		if n == math.Trunc(n) && math.Abs(n) < 1<<53 {
+			return int64(n), nil
+		}
+		return n, nil
+	}
+	return raw, nil
+}
+
+func isEmptyRow(cols []string) bool {
+	for _, c := range cols {
+		if strings.TrimSpace(c) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// builtInDateFormats are the built-in number formats of dates and times
+var builtInDateFormats = map[int]bool{
+	14: true, 15: true, 16: true, 17: true, 18: true, 19: true, 20: true, 21: true, 22: true,
+	27: true, 28: true, 29: true, 30: true, 31: true, 32: true, 33: true, 34: true, 35: true, 36: true,
+	45: true, 46: true, 47: true, 50: true, 51: true, 52: true, 53: true, 54: true, 55: true, 56: true, 57: true, 58: true,
+}
+
+// literalFormatParts are the quoted, escaped and bracketed parts of a number
+// format, which don't tell whether it formats dates
+var literalFormatParts = regexp.MustCompile(`"[^"]*"|\\.|\[[^\]]*\]`)
+
+// xlsxDateFormats maps the cell styles formatting dates and times to the
+// layout of their values, date only or RFC 3339
+func xlsxDateFormats(f *excelize.File) (layouts map[int]string) {
+	layouts = map[int]string{}
+	if f.Styles == nil || f.Styles.CellXfs == nil {
+		return layouts
+	}
+
+	customFormats := map[int]string{}
+	if f.Styles.NumFmts != nil {
+		for _, nf := range f.Styles.NumFmts.NumFmt {
+			customFormats[nf.NumFmtID] = nf.FormatCode
+		}
+	}
+
+	for i, xf := range f.Styles.CellXfs.Xf {
+		if xf.NumFmtID == nil {
+			continue
+		}
+		id := *xf.NumFmtID
+
+		code, custom := customFormats[id]
+		if !custom {
+			if builtInDateFormats[id] {
+				if id >= 18 && id <= 21 || id >= 45 && id <= 47 {
+					layouts[i] = "15:04:05"
+				} else if id == 22 {
+					layouts[i] = time.RFC3339
+				} else {
+					layouts[i] = "2006-01-02"
+				}
+			}
+			continue
+		}
+
+		code = strings.ToLower(literalFormatParts.ReplaceAllString(code, ""))
+		hasDate := strings.ContainsAny(code, "yd")
+		hasTime := strings.ContainsAny(code, "hs")
+		switch {
+		case hasDate && hasTime:
+			layouts[i] = time.RFC3339
+		case hasDate:
+			layouts[i] = "2006-01-02"
+		case hasTime:
+			layouts[i] = "15:04:05"
+		}
+	}
+	return layouts
+}
@@ -0,0 +1,234 @@
+package qa
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// writeTestWorkbook writes an Excel file with a sheet of typed and formatted
+// cells below an empty row, and a second sheet of names
+func writeTestWorkbook(t *testing.T, filename string, date1904 bool) {
+	t.Helper()
+	f := excelize.NewFile()
+	f.SetSheetName(f.GetSheetName(0), "Items")
+	f.NewSheet("Other")
+	if date1904 {
+		f.GetSheetList()
+		if f.WorkBook == nil || f.WorkBook.WorkbookPr == nil {
+			t.Fatal("got no workbook properties to set the 1904 date system")
+		}
+		f.WorkBook.WorkbookPr.Date1904 = true
+	}
+
+	style := func(s *excelize.Style) int {
+		id, err := f.NewStyle(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	customDateTime := "yyyy-mm-dd hh:mm"
+	customLiteral := `"day "0`
+	styles := map[string]int{
+		"date":     style(&excelize.Style{NumFmt: 14}),
+		"time":     style(&excelize.Style{NumFmt: 21}),
+		"datetime": style(&excelize.Style{CustomNumFmt: &customDateTime}),
+		"literal":  style(&excelize.Style{CustomNumFmt: &customLiteral}),
+		"percent":  style(&excelize.Style{NumFmt: 10}),
+	}
+
+	set := func(sheet string, axis string, value interface{}, styleName string) {
+		if err := f.SetCellValue(sheet, axis, value); err != nil {
+			t.Fatal(err)
+		}
+		if styleName == "" {
+			return
+		}
+		if err := f.SetCellStyle(sheet, axis, axis, styles[styleName]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the header is on the second row
+	headers := []string{"id", "price", "ok", "code", "day", "at", "updated", "count", "rate", " label "}
+	for i, h := range headers {
+		axis, _ := excelize.CoordinatesToCellName(i+1, 2)
+		set("Items", axis, h, "")
+	}
+	set("Items", "A3", 1, "")
+	set("Items", "B3", 9.99, "")
+	set("Items", "C3", true, "")
+	set("Items", "D3", "00123", "")
+	set("Items", "E3", 45000, "date")
+	set("Items", "F3", 0.5, "time")
+	set("Items", "G3", 45000.25, "datetime")
+	set("Items", "H3", 3, "literal")
+	set("Items", "I3", 0.25, "percent")
+	set("Items", "J3", "a", "")
+	// an empty row is skipped, so are empty cells
+	set("Items", "A5", 2, "")
+	set("Items", "C5", false, "")
+	set("Items", "D5", "TRUE", "")
+	set("Items", "E5", 45001, "date")
+	set("Items", "J5", "b", "")
+
+	set("Other", "A1", "name", "")
+	set("Other", "A2", "x", "")
+
+	if err := f.SaveAs(filename); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestXLSXFileProcessor(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "book.xlsx")
+	writeTestWorkbook(t, filename, false)
+	filename1904 := filepath.Join(dir, "book1904.xlsx")
+	writeTestWorkbook(t, filename1904, true)
+
+	items := []map[string]interface{}{
+		{
+			"_collection": "Items",
+			"id":          int64(1),
+			"price":       9.99,
+			"ok":          true,
+			"code":        "00123",
+			"day":         "2023-03-15",
+			"at":          "12:00:00",
+			"updated":     "2023-03-15T06:00:00Z",
+			"count":       int64(3),
+			"rate":        0.25,
+			"label":       "a",
+		},
+		{
+			"_collection": "Items",
+			"id":          int64(2),
+			"ok":          false,
+			"code":        "TRUE",
+			"day":         "2023-03-16",
+			"label":       "b",
+		},
+	}
+	other := []map[string]interface{}{{"_collection": "Other", "name": "x"}}
+	itemHeaders := []string{"id", "price", "ok", "code", "day", "at", "updated", "count", "rate", "label"}
+
+	tests := []struct {
+		name     string
+		filename string
+		xo       xlsxOptions
+		headers  [][]string
+		want     []map[string]interface{}
+	}{
+		{
+			name:     "all sheets",
+			filename: filename,
+			headers:  [][]string{itemHeaders, {"name"}},
+			want:     append(append([]map[string]interface{}{}, items...), other...),
+		},
+		{
+			name:     "sheet by index",
+			filename: filename,
+			xo:       xlsxOptions{sheets: []string{"2"}},
+			headers:  [][]string{{"name"}},
+			want:     other,
+		},
+		{
+			name:     "sheet by name",
+			filename: filename,
+			xo:       xlsxOptions{sheets: []string{"Items", "1"}},
+			headers:  [][]string{itemHeaders},
+			want:     items,
+		},
+		{
+			name:     "header row",
+			filename: filename,
+			xo:       xlsxOptions{sheets: []string{"Items"}, headerRow: 2},
+			headers:  [][]string{itemHeaders},
+			want:     items,
+		},
+		{
+			name:     "last row as header row",
+			filename: filename,
+			xo:       xlsxOptions{sheets: []string{"Items"}, headerRow: 5},
+			headers:  [][]string{{"2", "", "0", "TRUE", "45001", "", "", "", "", "b"}},
+		},
+		{
+			name:     "1904 dates",
+			filename: filename1904,
+			xo:       xlsxOptions{sheets: []string{"Items"}},
+			headers:  [][]string{itemHeaders},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers, got := readTestRecords(t, xlsxFileProcessor(tt.xo), tt.filename)
+			if !reflect.DeepEqual(headers, tt.headers) {
+				t.Errorf("got headers %q, want %q", headers, tt.headers)
+			}
+			if tt.name == "1904 dates" {
+				if len(got) != 2 || got[0]["day"] != "2027-03-16" || got[0]["updated"] != "2027-03-16T06:00:00Z" || got[0]["at"] != "12:00:00" {
+					t.Errorf("got records %v, want the dates of the 1904 date system", got)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got records %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestXLSXFileProcessorMissingSheet(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "book.xlsx")
+	writeTestWorkbook(t, filename, false)
+
+	for _, sheet := range []string{"Missing", "0", "3"} {
+		err := xlsxFileProcessor(xlsxOptions{sheets: []string{sheet}})(filename, 10, nil, nil, nil)
+		if err == nil || !strings.Contains(err.Error(), "not found") {
+			t.Errorf("got error %v for sheet %v, want not found", err, sheet)
+		}
+	}
+}
+
+func TestXLSXDateFormats(t *testing.T) {
+	f := excelize.NewFile()
+	codes := map[string]string{
+		"yyyy-mm-dd":            "2006-01-02",
+		"dd/mm/yyyy hh:mm:ss":   "2006-01-02T15:04:05Z07:00",
+		"[h]:mm:ss":             "15:04:05",
+		`"days "0`:              "",
+		`0.00\d`:                "",
+		`[$-409]mmmm d, yyyy;@`: "2006-01-02",
+		"#,##0.00":              "",
+	}
+	ids := map[int]string{}
+	for code, layout := range codes {
+		code := code
+		id, err := f.NewStyle(&excelize.Style{CustomNumFmt: &code})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[id] = layout
+	}
+	builtIns := map[int]string{14: "2006-01-02", 20: "15:04:05", 22: "2006-01-02T15:04:05Z07:00", 2: ""}
+	for numFmt, layout := range builtIns {
+		id, err := f.NewStyle(&excelize.Style{NumFmt: numFmt})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids[id] = layout
+	}
+
+	layouts := xlsxDateFormats(f)
+	for id, want := range ids {
+		if got := layouts[id]; got != want {
+			t.Errorf("got layout %q for style %v, want %q", got, id, want)
+		}
+	}
+}